@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// images and files in PR bodies that are hosted by bitbucket are copied to this branch of the Github repo.
+// it only exists in github
+const attachmentsBranch = "bitbucket-attachments"
+
+// a file hosted by bitbucket that a PR body links to
+type plannedAttachment struct {
+	URL string `json:"url"`
+	// where it goes in attachmentsBranch
+	Path string `json:"path"`
+}
+
+// images uploaded to PRs, repo downloads and the s3 bucket bitbucket serves uploads from.
+// trailing punctuation is left out like in bitbucketLinkPath
+func attachmentLinks(config settings) *regexp.Regexp {
+	host := `(?:[\w-]+\.)?` + regexp.QuoteMeta(bitbucketHost(config))
+	return regexp.MustCompile(`https?://(?:` + host + `/[^\s()<>\[\]"']*/(?:images|downloads|attachments)|bbuseruploads\.s3\.amazonaws\.com)/[^\s()<>\[\]"']*[^\s()<>\[\]"'.,;:!?]`)
+}
+
+// plans copying every bitbucket hosted file the PR and issue bodies link to
+func planAttachments(plan *repoPlan, config settings) {
+	links := attachmentLinks(config)
+	var bodies []string
+	for _, pr := range plan.PullRequests {
+		bodies = append(bodies, pr.Body)
+	}
+	for _, issue := range plan.Issues {
+		bodies = append(bodies, issue.Body)
+	}
+	for _, body := range bodies {
+		for _, link := range links.FindAllString(body, -1) {
+			if slices.ContainsFunc(plan.Attachments, func(a plannedAttachment) bool { return a.URL == link }) {
+				continue
+			}
+			plan.Attachments = append(plan.Attachments, plannedAttachment{URL: link, Path: attachmentPath(link)})
+		}
+	}
+}
+
+// a path that is unique for the url and keeps the file name, so Github shows images and downloads it by name
+func attachmentPath(link string) string {
+	hash := sha256.Sum256([]byte(link))
+	name := "attachment"
+	if parsed, err := url.Parse(link); err == nil {
+		if base := path.Base(parsed.Path); base != "." && base != "/" {
+			name = base
+		}
+	}
+	name = unsafeFileChars.ReplaceAllString(name, "_")
+	return hex.EncodeToString(hash[:6]) + "-" + name
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^\w.-]`)
+
+// downloads a file linked from a PR. the credentials are only sent to bitbucket itself,
+// s3 links are signed and go's client drops them on redirects to other hosts
+func downloadAttachment(config settings, link string) ([]byte, error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	if host := bitbucketHost(config); req.URL.Host == host || strings.HasSuffix(req.URL.Host, "."+host) {
+		err = authenticateBitbucketRequest(req, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp, err := config.apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s failed with %s", link, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// downloads the attachments of the plan and commits them to attachmentsBranch.
+// returns the Github url of every attachment that was copied, the links to the others are left as they are
+func copyAttachments(src source, dst target, plan repoPlan) map[string]string {
+	files := map[string][]byte{}
+	for _, attachment := range plan.Attachments {
+		content, err := src.downloadAttachment(attachment.URL)
+		if err != nil {
+			fmt.Printf("Could not download %s, leaving the link as is: %s\n", attachment.URL, err)
+			continue
+		}
+		files[attachment.Path] = content
+	}
+	copied := map[string]string{}
+	if len(files) == 0 {
+		return copied
+	}
+	fmt.Printf("Copying %d attachments to branch %s\n", len(files), attachmentsBranch)
+	urls := dst.uploadAttachments(plan, files)
+	for _, attachment := range plan.Attachments {
+		if githubURL, ok := urls[attachment.Path]; ok {
+			copied[attachment.URL] = githubURL
+		}
+	}
+	return copied
+}
+
+// points the links to copied attachments at their Github copy
+func rewriteAttachments(text string, copied map[string]string) string {
+	if len(copied) == 0 {
+		return text
+	}
+	// longest first, so a link that starts with another link isn't cut in half
+	links := make([]string, 0, len(copied))
+	for link := range copied {
+		links = append(links, link)
+	}
+	slices.SortFunc(links, func(a, b string) int { return len(b) - len(a) })
+	var pairs []string
+	for _, link := range links {
+		pairs = append(pairs, link, copied[link])
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanAttachments(t *testing.T) {
+	config := settings{bbURL: "https://bitbucket.org"}
+	image := "https://bitbucket.org/repo/x8kGzA/images/1234-screen%20shot.png"
+	download := "https://bitbucket.org/ws/app/downloads/report.pdf"
+	plan := repoPlan{
+		PullRequests: []plannedPullRequest{{Body: "before ![screen shot](" + image + ") and " + image + "."}},
+		Issues:       []plannedIssue{{Body: "see " + download + ", and https://bitbucket.org/ws/app/src/main/README.md"}},
+	}
+	planAttachments(&plan, config)
+	if len(plan.Attachments) != 2 || plan.Attachments[0].URL != image || plan.Attachments[1].URL != download {
+		t.Fatalf("unexpected attachments: %+v", plan.Attachments)
+	}
+	if path := plan.Attachments[0].Path; !strings.HasSuffix(path, "-1234-screen_shot.png") || path == attachmentPath(download) {
+		t.Errorf("unexpected attachment path %s", path)
+	}
+
+	copied := map[string]string{image: "https://github.com/org/app/raw/" + attachmentsBranch + "/image.png"}
+	expected := "before ![screen shot](https://github.com/org/app/raw/" + attachmentsBranch + "/image.png) and see " + download
+	if actual := rewriteAttachments("before ![screen shot]("+image+") and see "+download, copied); actual != expected {
+		t.Errorf("unexpected rewrite: %s", actual)
+	}
+}
+
+func TestMigrateAttachments(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	bb.addRepo("my-repo", "My Repo", "PROJ")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	image := bb.addAttachment("diagram.png", []byte("png bytes"))
+	missing := bb.server.URL + "/repo/99/images/gone.png"
+	bb.prs["my-repo"][0]["summary"] = map[string]any{"raw": "![diagram](" + image + ") ![gone](" + missing + ")"}
+
+	config := testSettings(t, bb, gh)
+	config.migrateAttachments = true
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := []repoEntry{{name: "my-repo", slug: "my-repo"}}
+	migrateRepos(ghClient, bbClient, repos, config)
+
+	path := attachmentPath(image)
+	githubURL := githubWebURL(config) + "/" + fakeGithubOrg + "/my-repo/raw/" + attachmentsBranch + "/" + path
+	body := gh.repo("my-repo").issues[0].Body
+	if !strings.Contains(body, "![diagram]("+githubURL+")") || !strings.Contains(body, "![gone]("+missing+")") {
+		t.Errorf("expected only the downloaded attachment to be rewritten:\n%s", body)
+	}
+	ghRepo := filepath.Join(gh.gitRoot, fakeGithubOrg, "my-repo.git")
+	if content := runGit(t, ghRepo, "show", attachmentsBranch+":"+path); content != "png bytes" {
+		t.Errorf("unexpected attachment content %q", content)
+	}
+	// the branch only exists in github
+	if !verifyRepos(ghClient, bbClient, repos, config) {
+		t.Error("verify found differences after migrating")
+	}
+
+	// later runs add to the branch
+	plan := repoPlan{GithubOrg: fakeGithubOrg, GithubName: "my-repo"}
+	uploadGithubAttachments(ghClient, plan, map[string][]byte{"other.txt": []byte("more")}, config)
+	if files := runGit(t, ghRepo, "ls-tree", "--name-only", attachmentsBranch); !strings.Contains(files, "other.txt") || !strings.Contains(files, path) {
+		t.Errorf("expected both attachments in the branch, got %q", files)
+	}
+}
+
+func TestAttachmentsInEmptyRepo(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	config := testSettings(t, bb, gh)
+	ghClient, _, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := repoPlan{GithubOrg: fakeGithubOrg, GithubName: "empty-repo"}
+	createRepo(ghClient, plan)
+
+	if urls := uploadGithubAttachments(ghClient, plan, map[string][]byte{"image.png": []byte("png")}, config); len(urls) != 0 {
+		t.Errorf("expected no attachments to be copied into a repo without commits, got %v", urls)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// go-bitbucket doesn't cover every endpoint and its types drop fields,
+// so these fetch the raw json for when we need all of it
+
+// returned for 404s, which bitbucket also uses for features that are turned off, eg the issue tracker
+var errBitbucketNotFound = errors.New("not found in bitbucket")
+
+// one page of a paginated bitbucket response
+type bitbucketPage struct {
+	Values []json.RawMessage `json:"values"`
+	Next   string            `json:"next"`
+}
+
+// gets path from the bitbucket api, path is relative to BITBUCKET_API_BASE_URL
+func bitbucketGet(config settings, path string) (json.RawMessage, error) {
+	return bitbucketGetURL(config, strings.TrimSuffix(config.bbAPIURL, "/")+path)
+}
+
+// gets every page of a paginated bitbucket endpoint and returns all values
+func bitbucketGetAll(config settings, path string) ([]json.RawMessage, error) {
+	next := strings.TrimSuffix(config.bbAPIURL, "/") + path
+	values := []json.RawMessage{}
+	for next != "" {
+		body, err := bitbucketGetURL(config, next)
+		if err != nil {
+			return nil, err
+		}
+		var page bitbucketPage
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page from %s: %w", next, err)
+		}
+		values = append(values, page.Values...)
+		next = page.Next
+	}
+	return values, nil
+}
+
+func bitbucketGetURL(config settings, rawURL string) (json.RawMessage, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = authenticateBitbucketRequest(req, config)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := config.apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", req.URL.Path, errBitbucketNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s failed with %s: %s", req.URL.Path, resp.Status, body)
+	}
+	return body, nil
+}
+
+// adds the same credentials to req that the bitbucket client uses
+func authenticateBitbucketRequest(req *http.Request, config settings) error {
+	if config.bbTokenSource == nil {
+		req.SetBasicAuth(config.bbUsername, config.bbPassword)
+		return nil
+	}
+	token, err := config.bbTokenSource.Token()
+	if err != nil {
+		return fmt.Errorf("failed to get bitbucket token: %w", err)
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
+
+// returns the api path of a repo in the workspace, eg /repositories/workspace/slug
+func bitbucketRepoPath(config settings, slug string) string {
+	return "/repositories/" + url.PathEscape(config.bbWorkspace) + "/" + url.PathEscape(slug)
+}
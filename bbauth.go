@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ktrysmt/go-bitbucket"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// git username bitbucket expects when cloning with an access token or OAuth token
+const bitbucketTokenGitUser = "x-token-auth"
+
+// creates the bitbucket client from whichever credentials are configured:
+// a workspace/repository access token, an OAuth consumer, or a username and app password
+func newBitbucketClient(config settings) (*bitbucket.Client, oauth2.TokenSource, error) {
+	apiURL, err := url.Parse(config.bbAPIURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("BITBUCKET_API_BASE_URL is not a valid url: %w", err)
+	}
+	client, tokenSource, err := newAuthenticatedBitbucketClient(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	client.SetApiBaseURL(*apiURL)
+	return client, tokenSource, nil
+}
+
+func newAuthenticatedBitbucketClient(config settings) (*bitbucket.Client, oauth2.TokenSource, error) {
+	switch {
+	case config.bbAccessToken != "":
+		client := bitbucket.NewOAuthbearerToken(config.bbAccessToken)
+		client.HttpClient = config.apiClient
+		return client, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: config.bbAccessToken}), nil
+	case config.bbOAuthClientID != "" || config.bbOAuthClientSecret != "":
+		if config.bbOAuthClientID == "" || config.bbOAuthClientSecret == "" {
+			return nil, nil, errors.New("BITBUCKET_OAUTH_CLIENT_ID and BITBUCKET_OAUTH_CLIENT_SECRET must both be set")
+		}
+		oauthConfig := &clientcredentials.Config{
+			ClientID:     config.bbOAuthClientID,
+			ClientSecret: config.bbOAuthClientSecret,
+			TokenURL:     strings.TrimSuffix(config.bbURL, "/") + "/site/oauth2/access_token",
+		}
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, config.apiClient)
+		tokenSource := oauth2.ReuseTokenSource(nil, oauthConfig.TokenSource(ctx))
+		if _, err := tokenSource.Token(); err != nil {
+			return nil, nil, err
+		}
+		// OAuth tokens only last 2 hours, so instead of giving the client a fixed token
+		// we let the oauth2 transport add a fresh token to every request
+		client := bitbucket.NewOAuthbearerToken("")
+		client.HttpClient = oauth2.NewClient(ctx, tokenSource)
+		return client, tokenSource, nil
+	case config.bbUsername != "" && config.bbPassword != "":
+		client := bitbucket.NewBasicAuth(config.bbUsername, config.bbPassword)
+		client.HttpClient = config.apiClient
+		return client, nil, nil
+	}
+	return nil, nil, errors.New("BITBUCKET_USER and BITBUCKET_TOKEN, BITBUCKET_ACCESS_TOKEN, or BITBUCKET_OAUTH_CLIENT_ID and BITBUCKET_OAUTH_CLIENT_SECRET must be set")
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"slices"
@@ -16,11 +17,7 @@ import (
 )
 
 func getRepo(bb *bitbucket.Client, owner string, repoName string) *bitbucket.Repository {
-	ro := &bitbucket.RepositoryOptions{
-		Owner:    owner,
-		RepoSlug: repoName,
-	}
-	repo, err := bb.Repositories.Repository.Get(ro)
+	repo, err := findRepo(bb, owner, repoName)
 	if err != nil {
 		fmt.Printf("Failed to get repo from bitbucket")
 		panic(err)
@@ -28,6 +25,37 @@ func getRepo(bb *bitbucket.Client, owner string, repoName string) *bitbucket.Rep
 	return repo
 }
 
+// same as getRepo but returns the error instead of panicking
+func findRepo(bb *bitbucket.Client, owner string, repoName string) (*bitbucket.Repository, error) {
+	ro := &bitbucket.RepositoryOptions{
+		Owner:    owner,
+		RepoSlug: repoName,
+	}
+	return bb.Repositories.Repository.Get(ro)
+}
+
+// returns the url git should use to clone repo, based on the CLONE_VIA setting
+func bitbucketCloneURL(repo string, config settings) string {
+	return bitbucketFullNameCloneURL(config.bbWorkspace+"/"+repo, config)
+}
+
+// same as bitbucketCloneURL for a repo in any workspace, eg a fork. fullName is workspace/slug
+func bitbucketFullNameCloneURL(fullName string, config settings) string {
+	if strings.ToLower(config.cloneVia) == "ssh" {
+		return fmt.Sprintf("git@%s:%s.git", bitbucketHost(config), fullName)
+	}
+	return fmt.Sprintf("%s/%s.git", strings.TrimSuffix(config.bbURL, "/"), fullName)
+}
+
+// returns the host of BITBUCKET_URL, eg bitbucket.org
+func bitbucketHost(config settings) string {
+	bbURL, err := url.Parse(config.bbURL)
+	if err != nil || bbURL.Host == "" {
+		log.Fatalf("BITBUCKET_URL is not a valid url: %s", config.bbURL)
+	}
+	return bbURL.Host
+}
+
 // clones repo to a temp folder
 func cloneRepo(repo string, config settings) (tempfolderpath string) {
 	tempDir, err := os.MkdirTemp("", fmt.Sprintf("%s-%s-*", config.bbWorkspace, repo))
@@ -35,15 +63,13 @@ func cloneRepo(repo string, config settings) (tempfolderpath string) {
 		log.Fatalf("Failed to create temp directory: %s", err)
 	}
 
-	var cloneURL string
-	if strings.ToLower(config.cloneVia) == "ssh" {
-		cloneURL = fmt.Sprintf("git@bitbucket.org:%s/%s.git", config.bbWorkspace, repo)
-	} else {
-		cloneURL = fmt.Sprintf("https://bitbucket.org/%s/%s.git", config.bbWorkspace, repo)
-	}
+	cloneURL := bitbucketCloneURL(repo, config)
 	fmt.Printf("Cloning repository %s to %s\n", repo, tempDir)
 
 	cmd := exec.Command("git", "clone", "--mirror", cloneURL, tempDir)
+	env, cleanup := gitAuthEnv(bitbucketGitCredentials(config))
+	defer cleanup()
+	cmd.Env = env
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		log.Fatalf("Failed to clone repository: %s\nOutput: %s", err, string(output))
@@ -53,10 +79,24 @@ func cloneRepo(repo string, config settings) (tempfolderpath string) {
 	return tempDir
 }
 
-func updatePermissionsToReadOnly(bb *bitbucket.Client, owner string, repoName string, dryRun bool) {
-	// number is arbitrary, just want to be nice to their API
-	const apiWaitTime = time.Millisecond * 16
+// fetches branch of the fork, a workspace/slug, into ref of the local repo
+func fetchForkBranch(repoFolder string, fork string, branch string, ref string, config settings) error {
+	fmt.Printf("Fetching branch %s of fork %s\n", branch, fork)
+	cmd := exec.Command("git", "fetch", bitbucketFullNameCloneURL(fork, config), "+refs/heads/"+branch+":"+ref)
+	cmd.Dir = repoFolder
+	env, cleanup := gitAuthEnv(bitbucketGitCredentials(config))
+	defer cleanup()
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, output)
+	}
+	return nil
+}
 
+// returns the changes that set every user and group permission of the repo to read.
+// permissions inherited from the project are not included
+func listReadOnlyPermissionChanges(bb *bitbucket.Client, owner string, repoName string) []permissionChange {
 	ro := &bitbucket.RepositoryOptions{
 		Owner:    owner,
 		RepoSlug: repoName,
@@ -70,47 +110,66 @@ func updatePermissionsToReadOnly(bb *bitbucket.Client, owner string, repoName st
 		panic(err)
 	}
 
-	if dryRun {
-		return
-	}
-
+	var changes []permissionChange
 	for _, userPerm := range user_perms.UserPermissions {
-		user := userPerm.User
-		permOpts := &bitbucket.RepositoryUserPermissionsOptions{
-			Owner:      owner,
-			RepoSlug:   repoName,
-			User:       user.AccountId,
-			Permission: "read",
+		if userPerm.Permission == "read" {
+			continue
 		}
-		_, err := bb.Repositories.Repository.SetUserPermissions(permOpts)
-		if err != nil {
-			log.Fatalf("Failed to update user permission for %s: %v", user.Username, err)
+		changes = append(changes, permissionChange{
+			Kind: "user",
+			ID:   userPerm.User.AccountId,
+			Name: userPerm.User.DisplayName,
+			From: userPerm.Permission,
+			To:   "read",
+		})
+	}
+	for _, groupPerm := range group_perms.GroupPermissions {
+		if groupPerm.Permission == "read" {
+			continue
 		}
-		time.Sleep(apiWaitTime)
+		changes = append(changes, permissionChange{
+			Kind: "group",
+			ID:   groupPerm.Group.Slug,
+			Name: groupPerm.Group.Name,
+			From: groupPerm.Permission,
+			To:   "read",
+		})
 	}
+	return changes
+}
 
-	for _, groupPerm := range group_perms.GroupPermissions {
-		groupSlug := groupPerm.Group.Slug
-		permOpts := &bitbucket.RepositoryGroupPermissionsOptions{
+func setPermission(bb *bitbucket.Client, owner string, repoName string, change permissionChange) {
+	// number is arbitrary, just want to be nice to their API
+	const apiWaitTime = time.Millisecond * 16
+
+	var err error
+	if change.Kind == "group" {
+		_, err = bb.Repositories.Repository.SetGroupPermissions(&bitbucket.RepositoryGroupPermissionsOptions{
 			Owner:      owner,
 			RepoSlug:   repoName,
-			Group:      groupSlug,
-			Permission: "read",
-		}
-		_, err := bb.Repositories.Repository.SetGroupPermissions(permOpts)
-		if err != nil {
-			log.Fatalf("Failed to update group permission for %s: %v", groupSlug, err)
-		}
-		time.Sleep(apiWaitTime)
+			Group:      change.ID,
+			Permission: change.To,
+		})
+	} else {
+		_, err = bb.Repositories.Repository.SetUserPermissions(&bitbucket.RepositoryUserPermissionsOptions{
+			Owner:      owner,
+			RepoSlug:   repoName,
+			User:       change.ID,
+			Permission: change.To,
+		})
 	}
+	if err != nil {
+		log.Fatalf("Failed to update %s permission for %s: %v", change.Kind, change.Name, err)
+	}
+	time.Sleep(apiWaitTime)
 }
 
-func getPrs(bb *bitbucket.Client, owner string, repo string, destinationBranch string) *PullRequests {
+// returns the PRs into every branch
+func getPrs(bb *bitbucket.Client, owner string, repo string) *PullRequests {
 	opt := &bitbucket.PullRequestsOptions{
-		Owner:             owner,
-		RepoSlug:          repo,
-		DestinationBranch: destinationBranch,
-		Query:             "state IN (\"MERGED\", \"OPEN\")",
+		Owner:    owner,
+		RepoSlug: repo,
+		Query:    "state IN (\"MERGED\", \"OPEN\", \"DECLINED\", \"SUPERSEDED\")",
 	}
 	fmt.Println("getting prs for", repo)
 	response, err := bb.Repositories.PullRequests.Gets(opt)
@@ -238,6 +297,23 @@ func decodePullRequests(reposResponse interface{}) (*PullRequests, error) {
 	return &pullRequests, nil
 }
 
+// decodes a repo from the api json, the same way go-bitbucket does
+func decodeRepository(response map[string]any) (*bitbucket.Repository, error) {
+	var repo = new(bitbucket.Repository)
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:     repo,
+		DecodeHook: stringToTimeHookFunc,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = decoder.Decode(response)
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
 func decodePullRequest(response interface{}) (*PullRequest, error) {
 	repoMap := response.(map[string]interface{})
 
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// a cassette is a directory with one json file per api request and its response.
+// recording one while running a failing migration lets the problem be reproduced
+// offline by replaying it, and the cassette can be attached to a bug report
+
+// headers that are never written to a cassette
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// json and form fields that are never written to a cassette, eg the tokens in token responses
+var redactedFields = []string{"token", "access_token", "refresh_token", "client_secret", "password"}
+
+const redacted = "REDACTED"
+
+type cassetteInteraction struct {
+	Request  cassetteMessage `json:"request"`
+	Response cassetteMessage `json:"response"`
+}
+
+// a request or response. Method and URL are only set for requests, Status only for responses
+type cassetteMessage struct {
+	Method string      `json:"method,omitempty"`
+	URL    string      `json:"url,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+	// set when Body is base64 because it wasn't valid utf-8
+	BodyBase64 bool `json:"body_base64,omitempty"`
+}
+
+// returns the http client all api requests go through, which records
+// or replays them depending on HTTP_CASSETTE_MODE
+func newAPIHTTPClient(config settings) (*http.Client, error) {
+	switch config.cassetteMode {
+	case "record":
+		recorder, err := newCassetteRecorder(config.cassetteDir, http.DefaultTransport)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("Recording api requests to", config.cassetteDir)
+		return &http.Client{Transport: recorder}, nil
+	case "replay":
+		player, err := newCassettePlayer(config.cassetteDir)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("Replaying api requests from", config.cassetteDir)
+		return &http.Client{Transport: player}, nil
+	}
+	return &http.Client{}, nil
+}
+
+// records every request sent through next
+type cassetteRecorder struct {
+	dir  string
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	count int
+}
+
+func newCassetteRecorder(dir string, next http.RoundTripper) (*cassetteRecorder, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cassette dir %s: %w", dir, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read cassette dir %s: %w", dir, err)
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("cassette dir %s is not empty, pick a new one so recordings don't get mixed up", dir)
+	}
+	return &cassetteRecorder{dir: dir, next: next}, nil
+}
+
+func (r *cassetteRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		requestBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	interaction := cassetteInteraction{
+		Request:  newCassetteMessage(req.Header, requestBody),
+		Response: newCassetteMessage(resp.Header, responseBody),
+	}
+	interaction.Request.Method = req.Method
+	interaction.Request.URL = req.URL.String()
+	interaction.Response.Status = resp.StatusCode
+	// each interaction is written straight away so the cassette is complete even if the migration crashes
+	return resp, r.write(interaction, req.URL.Host)
+}
+
+func (r *cassetteRecorder) write(interaction cassetteInteraction, host string) error {
+	data, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	name := fmt.Sprintf("%05d-%s-%s.json", r.count, interaction.Request.Method, strings.ReplaceAll(host, ":", "_"))
+	err = os.WriteFile(filepath.Join(r.dir, name), data, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write to cassette: %w", err)
+	}
+	return nil
+}
+
+// returns a message with the secrets removed from header and body
+func newCassetteMessage(header http.Header, body []byte) cassetteMessage {
+	header = header.Clone()
+	for _, name := range redactedHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, redacted)
+		}
+	}
+	body = redactBody(body, header.Get("Content-Type"))
+	if !utf8.Valid(body) {
+		return cassetteMessage{Header: header, Body: base64.StdEncoding.EncodeToString(body), BodyBase64: true}
+	}
+	return cassetteMessage{Header: header, Body: string(body)}
+}
+
+func redactBody(body []byte, contentType string) []byte {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return body
+		}
+		for _, field := range redactedFields {
+			if values.Has(field) {
+				values.Set(field, redacted)
+			}
+		}
+		return []byte(values.Encode())
+	}
+
+	var value any
+	if json.Unmarshal(body, &value) != nil {
+		return body
+	}
+	if !redactJSON(value) {
+		return body
+	}
+	redactedBody, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return redactedBody
+}
+
+// replaces the redacted fields anywhere in value, returns whether anything was replaced
+func redactJSON(value any) bool {
+	changed := false
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if slices.Contains(redactedFields, key) {
+				value[key] = redacted
+				changed = true
+			} else if redactJSON(field) {
+				changed = true
+			}
+		}
+	case []any:
+		for _, item := range value {
+			if redactJSON(item) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// answers requests with the responses from a cassette instead of sending them
+type cassettePlayer struct {
+	mu sync.Mutex
+	// recorded interactions by method and url, in the order they were recorded
+	interactions map[string][]cassetteInteraction
+	served       map[string]int
+}
+
+func newCassettePlayer(dir string) (*cassettePlayer, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read cassette dir %s: %w", dir, err)
+	}
+	player := &cassettePlayer{interactions: map[string][]cassetteInteraction{}, served: map[string]int{}}
+	// ReadDir sorts by name, which is the recording order
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var interaction cassetteInteraction
+		err = json.Unmarshal(data, &interaction)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cassette file %s: %w", entry.Name(), err)
+		}
+		key := interaction.Request.Method + " " + interaction.Request.URL
+		player.interactions[key] = append(player.interactions[key], interaction)
+	}
+	return player, nil
+}
+
+// request bodies aren't matched because they can contain timestamps.
+// when a request is sent more often than it was recorded the last response is repeated
+func (p *cassettePlayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key := req.Method + " " + req.URL.String()
+	p.mu.Lock()
+	recorded := p.interactions[key]
+	if len(recorded) == 0 {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("no response recorded in the cassette for %s", key)
+	}
+	i := min(p.served[key], len(recorded)-1)
+	p.served[key]++
+	p.mu.Unlock()
+
+	response := recorded[i].Response
+	body := []byte(response.Body)
+	if response.BodyBase64 {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body recorded in the cassette for %s: %w", key, err)
+		}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.Status, http.StatusText(response.Status)),
+		StatusCode:    response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        response.Header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
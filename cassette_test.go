@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	bb.addRepo("my-repo", "My Repo", "PROJ")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+
+	config := testSettings(t, bb, gh)
+	config.cassetteMode = "record"
+	config.cassetteDir = filepath.Join(t.TempDir(), "cassette")
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getRepo(bbClient, config.bbWorkspace, "my-repo")
+	getPrs(bbClient, config.bbWorkspace, "my-repo")
+	if findGithubRepo(ghClient, config.ghOrg, "my-repo") != nil {
+		t.Fatal("github repo should not exist yet")
+	}
+
+	files, err := os.ReadDir(config.cassetteDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 recorded requests, got %d", len(files))
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(config.cassetteDir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), fakeGithubToken) || strings.Contains(string(data), fakeBbPassword) {
+			t.Errorf("%s contains credentials", file.Name())
+		}
+	}
+
+	// the fakes are gone, so everything has to come from the cassette
+	bb.server.Close()
+	gh.server.Close()
+	config.cassetteMode = "replay"
+	config.apiClient = nil
+	ghClient, bbClient, err = newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := getRepo(bbClient, config.bbWorkspace, "my-repo")
+	if repo.Name != "My Repo" || repo.Mainbranch.Name != "main" {
+		t.Errorf("unexpected replayed repo %+v", repo)
+	}
+	prs := getPrs(bbClient, config.bbWorkspace, "my-repo")
+	if len(prs.Values) != 1 || prs.Values[0].Title != "PR number 1" {
+		t.Errorf("unexpected replayed PRs %+v", prs.Values)
+	}
+	if findGithubRepo(ghClient, config.ghOrg, "my-repo") != nil {
+		t.Error("replayed github repo should not exist")
+	}
+	if _, err := findRepo(bbClient, config.bbWorkspace, "not-recorded"); err == nil {
+		t.Error("expected an error for a request that was not recorded")
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	body := redactBody([]byte(`{"token":"secret","permissions":{"contents":"write"},"nested":[{"access_token":"secret"}]}`), "application/json")
+	if strings.Contains(string(body), "secret") || !strings.Contains(string(body), "contents") {
+		t.Errorf("json body was not redacted correctly: %s", body)
+	}
+	body = redactBody([]byte("grant_type=client_credentials&client_secret=secret"), "application/x-www-form-urlencoded")
+	if string(body) != "client_secret=REDACTED&grant_type=client_credentials" {
+		t.Errorf("form body was not redacted correctly: %s", body)
+	}
+}
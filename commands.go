@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/ktrysmt/go-bitbucket"
+)
+
+// compares the refs and default branch of every migrated repo with bitbucket.
+// returns false if any repo differs
+func verifyRepos(gh *github.Client, bb *bitbucket.Client, repos []repoEntry, config settings) bool {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPO\tGITHUB REPO\tSTATUS\tDETAIL")
+	passed := true
+	for _, repo := range repos {
+		repoConfig := repoSettings(repo, config)
+		bbRepo := getRepo(bb, repoConfig.bbWorkspace, repo.slug)
+		ghName := githubRepoName(repo, bbRepo, repoConfig)
+		problems := verifyRepo(gh, bbRepo, ghName, repoConfig)
+		status := checkPass
+		if len(problems) > 0 {
+			status = checkFail
+			passed = false
+		}
+		fmt.Fprintf(w, "%s\t%s/%s\t%s\t%s\n", repo.slug, repoConfig.ghOrg, ghName, status, strings.Join(problems, "; "))
+	}
+	w.Flush()
+	return passed
+}
+
+func verifyRepo(gh *github.Client, bbRepo *bitbucket.Repository, ghName string, config settings) []string {
+	ghRepo, _, err := gh.Repositories.Get(context.Background(), config.ghOrg, ghName)
+	if err != nil {
+		return []string{fmt.Sprintf("could not get github repo: %s", err)}
+	}
+
+	var problems []string
+	if ghRepo.GetDefaultBranch() != bbRepo.Mainbranch.Name {
+		problems = append(problems, fmt.Sprintf("default branch is %s, expected %s", ghRepo.GetDefaultBranch(), bbRepo.Mainbranch.Name))
+	}
+
+	bbRefs, err := listRemoteRefs(bitbucketCloneURL(bbRepo.Slug, config), bitbucketGitCredentials(config))
+	if err != nil {
+		return append(problems, fmt.Sprintf("could not list bitbucket refs: %s", err))
+	}
+	ghRefs, err := listRemoteRefs(githubCloneURL(ghName, config), githubGitCredentials(config))
+	if err != nil {
+		return append(problems, fmt.Sprintf("could not list github refs: %s", err))
+	}
+
+	var missing, different []string
+	for ref, hash := range bbRefs {
+		ghHash, ok := ghRefs[ref]
+		if !ok {
+			missing = append(missing, ref)
+		} else if ghHash != hash {
+			different = append(different, ref)
+		}
+	}
+	slices.Sort(missing)
+	slices.Sort(different)
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("missing in github: %s", strings.Join(missing, ", ")))
+	}
+	if len(different) > 0 {
+		problems = append(problems, fmt.Sprintf("different commit in github: %s", strings.Join(different, ", ")))
+	}
+	return problems
+}
+
+// returns the branches and tags of a remote repo, mapped to their commit hash
+func listRemoteRefs(url string, creds *gitCredentials) (map[string]string, error) {
+	cmd := exec.Command("git", "ls-remote", "--heads", "--tags", url)
+	env, cleanup := gitAuthEnv(creds)
+	defer cleanup()
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseRefs(output, "\t"), nil
+}
+
+// same as listRemoteRefs but exits if the refs can't be listed
+func mustListRemoteRefs(url string, creds *gitCredentials) map[string]string {
+	refs, err := listRemoteRefs(url, creds)
+	if err != nil {
+		log.Fatalf("Failed to list refs of %s: %s", url, err)
+	}
+	return refs
+}
+
+// returns the branches and tags of a local repo, mapped to their commit hash
+func localRefs(repoFolder string) (map[string]string, error) {
+	cmd := exec.Command("git", "for-each-ref", "--format=%(objectname) %(refname)", "refs/heads", "refs/tags")
+	cmd.Dir = repoFolder
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseRefs(output, " "), nil
+}
+
+// returns the full hash of the commit rev points at in the repo, false if it isn't there.
+// bitbucket only gives short hashes
+func resolveCommit(repoFolder string, rev string) (string, bool) {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	cmd.Dir = repoFolder
+	output, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(output)), true
+}
+
+// points the refs at their new commits in the local repo
+func updateLocalRefs(repoFolder string, updates []refUpdate) error {
+	for _, update := range updates {
+		cmd := exec.Command("git", "update-ref", update.Ref, update.New)
+		cmd.Dir = repoFolder
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("could not create %s: %s", update.Ref, output)
+		}
+	}
+	return nil
+}
+
+// parses lines of a hash and a ref name. peeled tags (ref^{}) are left out
+func parseRefs(output []byte, separator string) map[string]string {
+	refs := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		hash, ref, ok := strings.Cut(line, separator)
+		if ok && !strings.HasSuffix(ref, "^{}") {
+			refs[ref] = hash
+		}
+	}
+	return refs
+}
+
+// pushes the current bitbucket branches and tags to repos that were already migrated.
+// this is meant for the window between migrating and switching everyone over to github
+func syncRepos(gh *github.Client, bb *bitbucket.Client, repos []repoEntry, config settings) {
+	if config.dryRun {
+		fmt.Println("Dry Run - not actually syncing anything")
+	}
+	for _, repo := range repos {
+		repoConfig := repoSettings(repo, config)
+		bbRepo := getRepo(bb, repoConfig.bbWorkspace, repo.slug)
+		ghName := githubRepoName(repo, bbRepo, repoConfig)
+
+		if findGithubRepo(gh, repoConfig.ghOrg, ghName) == nil {
+			log.Fatalf("github repo %s/%s does not exist, migrate it before syncing", repoConfig.ghOrg, ghName)
+		}
+
+		bbRefs := mustListRemoteRefs(bitbucketCloneURL(repo.slug, repoConfig), bitbucketGitCredentials(repoConfig))
+		ghRefs := mustListRemoteRefs(githubCloneURL(ghName, repoConfig), githubGitCredentials(repoConfig))
+		updates := diffRefs(ghRefs, bbRefs)
+		fmt.Printf("Syncing %s to %s: %d refs to update\n", repo.slug, ghName, len(updates))
+		if len(updates) == 0 || repoConfig.dryRun {
+			continue
+		}
+		push := &refPush{Refs: updates}
+		if repoConfig.runProgram != "noop" {
+			push.Program = repoConfig.runProgram
+		}
+		repoFolder := cloneRepo(repo.slug, repoConfig)
+		if err := checkClonedRefs(repoFolder, updates); err != nil {
+			log.Fatalf("%s while syncing, run sync again", err)
+		}
+		pushRefsToGithub(repoFolder, ghName, push, repoConfig)
+		fmt.Print("-----------------------\n\n")
+	}
+}
+
+// prints a table summarizing each repo and its migration status
+func reportRepos(gh *github.Client, bb *bitbucket.Client, repos []repoEntry, config settings) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPO\tPROJECT\tGITHUB REPO\tVISIBILITY\tOPEN PRS\tMERGED PRS\tDECLINED PRS\tSUPERSEDED PRS\tGITHUB STATUS")
+	for _, repo := range repos {
+		repoConfig := repoSettings(repo, config)
+		bbRepo := getRepo(bb, repoConfig.bbWorkspace, repo.slug)
+		ghName := githubRepoName(repo, bbRepo, repoConfig)
+
+		visibility := "public"
+		if bbRepo.Is_private {
+			visibility = repoConfig.visibility
+		}
+
+		var open, merged, declined, superseded int
+		prs := getPrs(bb, repoConfig.bbWorkspace, repo.slug)
+		for _, pr := range prs.Values {
+			switch pr.State {
+			case "OPEN":
+				open++
+			case "MERGED":
+				merged++
+			case "DECLINED":
+				declined++
+			case "SUPERSEDED":
+				superseded++
+			}
+		}
+
+		status := "migrated"
+		_, resp, err := gh.Repositories.Get(context.Background(), repoConfig.ghOrg, ghName)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				status = "not migrated"
+			} else {
+				status = "unknown: " + err.Error()
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s/%s\t%s\t%s\t%s\t%s\t%s\t%s\n", repo.slug, bbRepo.Project.Key, repoConfig.ghOrg, ghName,
+			visibility, strconv.Itoa(open), strconv.Itoa(merged), strconv.Itoa(declined), strconv.Itoa(superseded), status)
+	}
+	w.Flush()
+}
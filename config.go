@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+type settingSetter func(config *settings, value string) error
+
+func setBool(field func(config *settings) *bool) settingSetter {
+	return func(config *settings, value string) error {
+		result, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a bool", value)
+		}
+		*field(config) = result
+		return nil
+	}
+}
+
+func setString(field func(config *settings) *string, choices ...string) settingSetter {
+	return func(config *settings, value string) error {
+		if len(choices) > 0 && !slices.ContainsFunc(choices, func(choice string) bool {
+			return strings.EqualFold(choice, value)
+		}) {
+			return fmt.Errorf("%q must be one of %s", value, strings.Join(choices, ", "))
+		}
+		if len(choices) > 0 {
+			value = strings.ToLower(value)
+		}
+		*field(config) = value
+		return nil
+	}
+}
+
+// a field of settings that can be set by flag, env var, config file
+// and, if perRepo is true, by a manifest row.
+// bool fields without a default must be set explicitly
+type settingField struct {
+	env        string
+	usage      string
+	defaultVal string
+	isBool     bool
+	perRepo    bool
+	set        settingSetter
+}
+
+// the flag name is the env var name in lowercase with dashes, eg --github-org
+func (field settingField) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(field.env), "_", "-")
+}
+
+func stringSetting(env string, defaultVal string, perRepo bool, usage string, field func(config *settings) *string, choices ...string) settingField {
+	return settingField{env: env, usage: usage, defaultVal: defaultVal, perRepo: perRepo, set: setString(field, choices...)}
+}
+
+func boolSetting(env string, defaultVal string, perRepo bool, usage string, field func(config *settings) *bool) settingField {
+	return settingField{env: env, usage: usage, defaultVal: defaultVal, isBool: true, perRepo: perRepo, set: setBool(field)}
+}
+
+var settingFields = []settingField{
+	stringSetting("BITBUCKET_WORKSPACE", "", false, "bitbucket workspace to migrate from",
+		func(c *settings) *string { return &c.bbWorkspace }),
+	stringSetting("BITBUCKET_USER", "", false, "bitbucket username",
+		func(c *settings) *string { return &c.bbUsername }),
+	stringSetting("BITBUCKET_TOKEN", "", false, "bitbucket app password",
+		func(c *settings) *string { return &c.bbPassword }),
+	stringSetting("BITBUCKET_ACCESS_TOKEN", "", false, "bitbucket workspace or repository access token, instead of BITBUCKET_USER and BITBUCKET_TOKEN",
+		func(c *settings) *string { return &c.bbAccessToken }),
+	stringSetting("BITBUCKET_OAUTH_CLIENT_ID", "", false, "key of a bitbucket OAuth consumer, instead of BITBUCKET_USER and BITBUCKET_TOKEN",
+		func(c *settings) *string { return &c.bbOAuthClientID }),
+	stringSetting("BITBUCKET_OAUTH_CLIENT_SECRET", "", false, "secret of the bitbucket OAuth consumer",
+		func(c *settings) *string { return &c.bbOAuthClientSecret }),
+	stringSetting("BITBUCKET_URL", "https://bitbucket.org", false, "url bitbucket repos are cloned from",
+		func(c *settings) *string { return &c.bbURL }),
+	stringSetting("BITBUCKET_API_BASE_URL", "https://api.bitbucket.org/2.0", false, "url of the bitbucket api",
+		func(c *settings) *string { return &c.bbAPIURL }),
+	boolSetting("BITBUCKET_REVOKEOLDPERMS", "", true, "set all bitbucket repo permissions to read when the migration starts",
+		func(c *settings) *bool { return &c.revokeOldPerms }),
+	stringSetting("CLONE_VIA", "https", true, "clone bitbucket repos via ssh or https",
+		func(c *settings) *string { return &c.cloneVia }, "ssh", "https"),
+	stringSetting("GITHUB_ORG", "", true, "github org to migrate to",
+		func(c *settings) *string { return &c.ghOrg }),
+	stringSetting("GITHUB_TOKEN", "", false, "github token",
+		func(c *settings) *string { return &c.ghToken }),
+	stringSetting("GITHUB_APP_ID", "", false, "id of the Github App to authenticate as instead of GITHUB_TOKEN",
+		func(c *settings) *string { return &c.ghAppID }),
+	stringSetting("GITHUB_APP_PRIVATE_KEY", "", false, "path to the Github App private key PEM file",
+		func(c *settings) *string { return &c.ghAppPrivateKey }),
+	stringSetting("GITHUB_APP_INSTALLATION_ID", "", false, "id of the Github App installation in GITHUB_ORG",
+		func(c *settings) *string { return &c.ghAppInstallationID }),
+	stringSetting("GITHUB_BASE_URL", "", false, "url of your Github Enterprise Server or GHE.com instance, empty for github.com",
+		func(c *settings) *string { return &c.ghBaseURL }),
+	stringSetting("GITHUB_UPLOAD_URL", "", false, "upload url of your Github instance, only needed if it isn't the default",
+		func(c *settings) *string { return &c.ghUploadURL }),
+	boolSetting("GITHUB_DRYRUN", "", true, "don't change anything in github",
+		func(c *settings) *bool { return &c.dryRun }),
+	boolSetting("GITHUB_OVERWRITE", "", true, "allow overwriting existing github repos",
+		func(c *settings) *bool { return &c.overwrite }),
+	stringSetting("GITHUB_PRIVATE_VISIBILITY", "internal", true, "github visibility of private bitbucket repos",
+		func(c *settings) *string { return &c.visibility }, "private", "internal"),
+	stringSetting("GITHUB_RUN_PROGRAM", "noop", true, "program to run on the repo before pushing to github",
+		func(c *settings) *string { return &c.runProgram }),
+	stringSetting("GITHUB_NAME_TEMPLATE", "{slug}", true, "name of the repo in github",
+		func(c *settings) *string { return &c.nameTemplate }),
+	stringSetting("REPO_FILE", "", false, "file with the repos to migrate",
+		func(c *settings) *string { return &c.repoFile }),
+	boolSetting("MIGRATE_REPO_CONTENTS", "", true, "migrate branches and tags",
+		func(c *settings) *bool { return &c.migrateRepoContents }),
+	boolSetting("MIGRATE_REPO_SETTINGS", "", true, "migrate default branch, topics and custom properties",
+		func(c *settings) *bool { return &c.migrateRepoSettings }),
+	boolSetting("MIGRATE_OPEN_PRS", "", true, "migrate open pull requests",
+		func(c *settings) *bool { return &c.migrateOpenPrs }),
+	boolSetting("MIGRATE_CLOSED_PRS", "", true, "migrate merged, declined and superseded pull requests as closed pull requests",
+		func(c *settings) *bool { return &c.migrateClosedPrs }),
+	boolSetting("ALIGN_PR_NUMBERS", "false", true, "fill gaps with closed placeholder issues so bitbucket PR N becomes github #N",
+		func(c *settings) *bool { return &c.alignPrNumbers }),
+	boolSetting("MIGRATE_ATTACHMENTS", "false", true, "copy images and files hosted by bitbucket that PR bodies link to into a branch of the github repo",
+		func(c *settings) *bool { return &c.migrateAttachments }),
+	stringSetting("USER_MAP_FILE", "", false, "CSV with bitbucket and github columns that maps bitbucket accounts to github logins",
+		func(c *settings) *string { return &c.userMapFile }),
+	boolSetting("REPO_DISCOVER", "false", false, "select repos from the bitbucket workspace instead of REPO_FILE",
+		func(c *settings) *bool { return &c.discoverRepos }),
+	stringSetting("REPO_PROJECT_KEYS", "", false, "comma separated project keys to select",
+		func(c *settings) *string { return &c.projectKeys }),
+	stringSetting("REPO_NAME_REGEX", "", false, "only select repos whose name or slug matches this regex",
+		func(c *settings) *string { return &c.nameRegex }),
+	stringSetting("REPO_UPDATED_SINCE", "", false, "only select repos updated on or after this date",
+		func(c *settings) *string { return &c.updatedSince }),
+	boolSetting("REPO_EXCLUDE_ARCHIVED", "false", false, "don't select repos in REPO_ARCHIVE_PROJECTS",
+		func(c *settings) *bool { return &c.excludeArchived }),
+	stringSetting("REPO_ARCHIVE_PROJECTS", "ARCHIVE", false, "comma separated project keys that hold archived repos",
+		func(c *settings) *string { return &c.archiveProjects }),
+	stringSetting("HTTP_CASSETTE_MODE", "off", false, "record api requests and responses to HTTP_CASSETTE_DIR, or replay them from it",
+		func(c *settings) *string { return &c.cassetteMode }, "off", "record", "replay"),
+	stringSetting("HTTP_CASSETTE_DIR", "cassette", false, "directory api requests are recorded to or replayed from",
+		func(c *settings) *string { return &c.cassetteDir }),
+	stringSetting("EXPORT_DIR", "export", false, "directory export writes the repo archives to and import reads them from",
+		func(c *settings) *string { return &c.exportDir }),
+	stringSetting("EXPORT_FORMAT", "btg", false, "write export archives for btg import, or as Github migration archives",
+		func(c *settings) *string { return &c.exportFormat }, "btg", "github"),
+	stringSetting("REF_MAP_FILE", "refmap.json", false, "file that maps migrated bitbucket repos and PRs to their Github repos and numbers",
+		func(c *settings) *string { return &c.refMapFile }),
+	stringSetting("PLAN_FILE", "plan.json", false, "file plan writes the migration plan to and apply reads it from",
+		func(c *settings) *string { return &c.planFile }),
+	stringSetting("REPO_FILE_OUTPUT", "", false, "write the resolved list of repos to this file",
+		func(c *settings) *string { return &c.repoFileOutput }),
+}
+
+func findSettingField(env string) (settingField, bool) {
+	index := slices.IndexFunc(settingFields, func(field settingField) bool {
+		return field.env == env
+	})
+	if index == -1 {
+		return settingField{}, false
+	}
+	return settingFields[index], true
+}
+
+// flag.Value that remembers whether it was set, so unset flags fall through to env vars
+type settingFlag struct {
+	value  string
+	isSet  bool
+	isBool bool
+}
+
+func (f *settingFlag) String() string { return f.value }
+
+func (f *settingFlag) Set(value string) error {
+	f.value = value
+	f.isSet = true
+	return nil
+}
+
+func (f *settingFlag) IsBoolFlag() bool { return f.isBool }
+
+// registers a flag for every setting on flags and returns a func that
+// builds settings once flags are parsed.
+// precedence is flags > env vars > config file > defaults
+func registerSettingFlags(flags *flag.FlagSet) func() (settings, error) {
+	configFile := flags.String("config", ".env", "optional config file in .env format")
+	settingFlags := map[string]*settingFlag{}
+	for _, field := range settingFields {
+		settingFlags[field.env] = &settingFlag{isBool: field.isBool}
+		flags.Var(settingFlags[field.env], field.flagName(), fmt.Sprintf("%s (env %s)", field.usage, field.env))
+	}
+
+	return func() (settings, error) {
+		fileValues, err := godotenv.Read(*configFile)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return settings{}, fmt.Errorf("could not read config file %s: %w", *configFile, err)
+			}
+			fileValues = map[string]string{}
+		}
+
+		var config settings
+		var errs []error
+		for _, field := range settingFields {
+			value := field.defaultVal
+			if flagValue := settingFlags[field.env]; flagValue.isSet {
+				value = flagValue.value
+			} else if envValue := os.Getenv(field.env); envValue != "" {
+				value = envValue
+			} else if fileValue := fileValues[field.env]; fileValue != "" {
+				value = fileValue
+			}
+			if value == "" {
+				if field.isBool {
+					errs = append(errs, fmt.Errorf("%s (--%s) must be set to true or false", field.env, field.flagName()))
+				}
+				continue
+			}
+			if err := field.set(&config, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", field.env, err))
+			}
+		}
+		return config, errors.Join(errs...)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parses args with the setting flags, reading the config file from dotenv
+func loadTestSettings(t *testing.T, dotenv string, args ...string) (settings, error) {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(configFile, []byte(dotenv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	loadSettings := registerSettingFlags(flags)
+	if err := flags.Parse(append([]string{"--config", configFile}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return loadSettings()
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	dotenv := `GITHUB_ORG=file-org
+BITBUCKET_WORKSPACE=file-workspace
+GITHUB_NAME_TEMPLATE=file-{slug}
+BITBUCKET_REVOKEOLDPERMS=false
+GITHUB_DRYRUN=false
+GITHUB_OVERWRITE=false
+MIGRATE_REPO_CONTENTS=true
+MIGRATE_REPO_SETTINGS=true
+MIGRATE_OPEN_PRS=true
+MIGRATE_CLOSED_PRS=true
+`
+	t.Setenv("GITHUB_ORG", "env-org")
+	t.Setenv("BITBUCKET_WORKSPACE", "env-workspace")
+	t.Setenv("GITHUB_NAME_TEMPLATE", "")
+	t.Setenv("GITHUB_RUN_PROGRAM", "")
+	t.Setenv("GITHUB_DRYRUN", "")
+
+	config, err := loadTestSettings(t, dotenv, "--github-org", "flag-org", "--github-dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ghOrg != "flag-org" {
+		t.Errorf("flag should win over env and config file, got GITHUB_ORG %q", config.ghOrg)
+	}
+	if config.bbWorkspace != "env-workspace" {
+		t.Errorf("env should win over config file, got BITBUCKET_WORKSPACE %q", config.bbWorkspace)
+	}
+	if config.nameTemplate != "file-{slug}" {
+		t.Errorf("config file should win over default, got GITHUB_NAME_TEMPLATE %q", config.nameTemplate)
+	}
+	if config.runProgram != "noop" {
+		t.Errorf("expected the default GITHUB_RUN_PROGRAM, got %q", config.runProgram)
+	}
+	if !config.dryRun {
+		t.Error("a bare bool flag should set GITHUB_DRYRUN to true")
+	}
+}
+
+func TestBoolSettingsWithoutDefaultMustBeSet(t *testing.T) {
+	dotenv := `BITBUCKET_REVOKEOLDPERMS=false
+GITHUB_DRYRUN=false
+MIGRATE_REPO_CONTENTS=true
+MIGRATE_REPO_SETTINGS=true
+MIGRATE_OPEN_PRS=true
+MIGRATE_CLOSED_PRS=true
+`
+	t.Setenv("GITHUB_OVERWRITE", "")
+	t.Setenv("ALIGN_PR_NUMBERS", "")
+
+	config, err := loadTestSettings(t, dotenv)
+	if err == nil || !strings.Contains(err.Error(), "GITHUB_OVERWRITE (--github-overwrite) must be set to true or false") {
+		t.Fatalf("expected an error for the unset GITHUB_OVERWRITE, got %v", err)
+	}
+	if strings.Contains(err.Error(), "ALIGN_PR_NUMBERS") || config.alignPrNumbers {
+		t.Errorf("ALIGN_PR_NUMBERS has a default and should be false, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ktrysmt/go-bitbucket"
+)
+
+// filters used to pick repos from the workspace instead of a REPO_FILE
+type repoSelector struct {
+	projectKeys     []string
+	nameRegex       *regexp.Regexp
+	updatedSince    time.Time
+	excludeArchived bool
+	archiveProjects []string
+}
+
+func newRepoSelector(config settings) repoSelector {
+	selector := repoSelector{
+		projectKeys:     splitList(config.projectKeys),
+		excludeArchived: config.excludeArchived,
+		archiveProjects: splitList(config.archiveProjects),
+	}
+	if config.nameRegex != "" {
+		nameRegex, err := regexp.Compile(config.nameRegex)
+		if err != nil {
+			log.Fatalf("REPO_NAME_REGEX is not a valid regex: %s", err)
+		}
+		selector.nameRegex = nameRegex
+	}
+	if config.updatedSince != "" {
+		updatedSince, err := time.Parse(time.DateOnly, config.updatedSince)
+		if err != nil {
+			log.Fatalf("REPO_UPDATED_SINCE must be a date like 2024-01-31: %s", err)
+		}
+		selector.updatedSince = updatedSince
+	}
+	return selector
+}
+
+// splits a comma separated list, ignoring empty entries
+func splitList(list string) []string {
+	var result []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func (s repoSelector) matches(repo bitbucket.Repository) bool {
+	if len(s.projectKeys) > 0 && !slices.Contains(s.projectKeys, repo.Project.Key) {
+		return false
+	}
+	// Bitbucket Cloud has no archive flag, so archived repos are the ones moved to an archive project
+	if s.excludeArchived && slices.Contains(s.archiveProjects, repo.Project.Key) {
+		return false
+	}
+	if s.nameRegex != nil && !s.nameRegex.MatchString(repo.Name) && !s.nameRegex.MatchString(repo.Slug) {
+		return false
+	}
+	if !s.updatedSince.IsZero() {
+		updatedOn, err := time.Parse(time.RFC3339Nano, repo.UpdatedOn)
+		if err != nil || updatedOn.Before(s.updatedSince) {
+			return false
+		}
+	}
+	return true
+}
+
+// lists every repo in the workspace
+func listWorkspaceRepos(bb *bitbucket.Client, workspace string) []bitbucket.Repository {
+	// 100 is the max page size bitbucket allows when listing repos
+	pagelen := bb.Pagelen
+	bb.Pagelen = 100
+	defer func() { bb.Pagelen = pagelen }()
+
+	fmt.Println("listing repos in bitbucket workspace", workspace)
+	response, err := bb.Repositories.ListForAccount(&bitbucket.RepositoriesOptions{Owner: workspace})
+	if err != nil {
+		log.Fatalf("failed to list repos in workspace %s: %s", workspace, err)
+	}
+	return response.Items
+}
+
+// returns the repos in the workspace matching the selector
+func discoverRepos(bb *bitbucket.Client, config settings) []repoEntry {
+	selector := newRepoSelector(config)
+	var repos []repoEntry
+	for _, repo := range listWorkspaceRepos(bb, config.bbWorkspace) {
+		if selector.matches(repo) {
+			repos = append(repos, repoEntry{name: repo.Name, slug: repo.Slug})
+		}
+	}
+	slices.SortFunc(repos, func(i repoEntry, j repoEntry) int {
+		return strings.Compare(i.slug, j.slug)
+	})
+	fmt.Printf("selected %d repos from workspace %s\n", len(repos), config.bbWorkspace)
+	return repos
+}
+
+// writes repos in the REPO_FILE format so the list can be reviewed and reused
+func writeRepoFile(repoFile string, repos []repoEntry, config settings) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# repos selected from bitbucket workspace %s on %s\n", config.bbWorkspace, time.Now().Format(time.DateOnly))
+	for _, repo := range repos {
+		if repo.target != "" {
+			fmt.Fprintf(&sb, "%s,%s\n", repo.slug, repo.target)
+		} else {
+			sb.WriteString(repo.slug + "\n")
+		}
+	}
+	err := os.WriteFile(repoFile, []byte(sb.String()), 0644)
+	if err != nil {
+		log.Fatalf("could not write file %s: %s", repoFile, err)
+	}
+	fmt.Println("wrote repo list to", repoFile)
+}
+
+// resolves repo file entries to slugs using the workspace listing.
+// an entry can be an exact slug, an exact name, or a case-insensitive name.
+// entries that are missing or ambiguous are returned as problems
+func resolveRepos(bb *bitbucket.Client, workspace string, entries []repoEntry) (resolved []repoEntry, problems []string) {
+	workspaceRepos := listWorkspaceRepos(bb, workspace)
+	for _, entry := range entries {
+		slug, err := resolveRepo(workspaceRepos, entry.name)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		entry.slug = slug
+		resolved = append(resolved, entry)
+	}
+	return resolved, problems
+}
+
+func resolveRepo(workspaceRepos []bitbucket.Repository, entry string) (string, error) {
+	for _, repo := range workspaceRepos {
+		if repo.Slug == entry {
+			return repo.Slug, nil
+		}
+	}
+
+	matchers := []func(repo bitbucket.Repository) bool{
+		func(repo bitbucket.Repository) bool { return repo.Name == entry },
+		func(repo bitbucket.Repository) bool {
+			return strings.EqualFold(repo.Name, entry) || strings.EqualFold(repo.Slug, entry)
+		},
+	}
+	for _, matcher := range matchers {
+		var matches []string
+		for _, repo := range workspaceRepos {
+			if matcher(repo) {
+				matches = append(matches, repo.Slug)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+		if len(matches) > 1 {
+			return "", fmt.Errorf("%q is ambiguous, it matches %s", entry, strings.Join(matches, ", "))
+		}
+	}
+	return "", fmt.Errorf("%q does not match any repo slug or name in the workspace", entry)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// the version of the export archive layout, bumped when it changes incompatibly
+const exportFormatVersion = 1
+
+const (
+	exportManifestFile  = "manifest.json"
+	exportChecksumsFile = "checksums.sha256"
+	exportRepoFile      = "repo.json"
+	exportMirrorDir     = "repo.git"
+	exportPrsDir        = "pull_requests"
+	exportIssuesDir     = "issues"
+)
+
+// describes an export archive, it is the first file in the archive
+type exportManifest struct {
+	FormatVersion int       `json:"format_version"`
+	Workspace     string    `json:"workspace"`
+	Slug          string    `json:"slug"`
+	ExportedAt    time.Time `json:"exported_at"`
+	// data bitbucket doesn't have for the repo, eg issues when the issue tracker is turned off
+	Unavailable []string     `json:"unavailable,omitempty"`
+	Files       []exportFile `json:"files"`
+}
+
+type exportFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// a pull request with its comments, activity and tasks, as returned by the bitbucket api
+type exportedPullRequest struct {
+	PullRequest json.RawMessage   `json:"pull_request"`
+	Comments    []json.RawMessage `json:"comments"`
+	Activity    []json.RawMessage `json:"activity"`
+	Tasks       []json.RawMessage `json:"tasks"`
+}
+
+// an issue with its comments, as returned by the bitbucket api
+type exportedIssue struct {
+	Issue    json.RawMessage   `json:"issue"`
+	Comments []json.RawMessage `json:"comments"`
+}
+
+// the permissions given directly on the repo
+type exportedPermissions struct {
+	Users  []json.RawMessage `json:"users"`
+	Groups []json.RawMessage `json:"groups"`
+}
+
+// writes an archive of every repo to EXPORT_DIR
+func exportRepos(repos []repoEntry, config settings) {
+	if config.exportFormat == "github" && config.ghOrg == "" {
+		log.Fatalf("EXPORT_FORMAT=github needs GITHUB_ORG, Github migration archives point at the repo's new location")
+	}
+	err := os.MkdirAll(config.exportDir, 0755)
+	if err != nil {
+		log.Fatalf("could not create export dir %s: %s", config.exportDir, err)
+	}
+	for _, repo := range repos {
+		repoConfig := repoSettings(repo, config)
+		archive := filepath.Join(config.exportDir, fmt.Sprintf("%s-%s.tar.gz", repoConfig.bbWorkspace, repo.slug))
+		fmt.Println("Exporting", repo.slug, "to", archive)
+		err := exportRepo(repo, archive, repoConfig)
+		if err != nil {
+			log.Fatalf("failed to export %s: %s", repo.slug, err)
+		}
+		fmt.Print("-----------------------\n\n")
+	}
+}
+
+func exportRepo(repo repoEntry, archive string, config settings) error {
+	slug := repo.slug
+	staging, err := os.MkdirTemp("", fmt.Sprintf("%s-%s-export-*", config.bbWorkspace, slug))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest := exportManifest{
+		FormatVersion: exportFormatVersion,
+		Workspace:     config.bbWorkspace,
+		Slug:          slug,
+		ExportedAt:    time.Now().UTC(),
+	}
+	err = exportMetadata(staging, slug, config, &manifest)
+	if err != nil {
+		return err
+	}
+
+	mirror := cloneRepo(slug, config)
+	err = os.Rename(mirror, filepath.Join(staging, exportMirrorDir))
+	if err != nil {
+		os.RemoveAll(mirror)
+		return err
+	}
+
+	if config.exportFormat == "github" {
+		var repoJSON map[string]any
+		err = readArchiveJSON(staging, exportRepoFile, &repoJSON)
+		if err != nil {
+			return err
+		}
+		bbRepo, err := decodeRepository(repoJSON)
+		if err != nil {
+			return err
+		}
+		return writeGithubMigrationArchive(staging, archive, githubRepoName(repo, bbRepo, config), config)
+	}
+	return writeExportArchive(staging, archive, manifest)
+}
+
+// writes the api data of the repo as json files to dir
+func exportMetadata(dir string, slug string, config settings, manifest *exportManifest) error {
+	repoPath := bitbucketRepoPath(config, slug)
+	// 404s mean the feature is turned off or doesn't apply, which is worth recording but not failing for
+	unavailable := func(name string, err error) error {
+		if errors.Is(err, errBitbucketNotFound) {
+			manifest.Unavailable = append(manifest.Unavailable, name)
+			return nil
+		}
+		return fmt.Errorf("could not export %s: %w", name, err)
+	}
+
+	repo, err := bitbucketGet(config, repoPath)
+	if err != nil {
+		return fmt.Errorf("could not export repo: %w", err)
+	}
+	err = writeJSONFile(filepath.Join(dir, exportRepoFile), repo)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Exporting pull requests")
+	prs, err := bitbucketGetAll(config, repoPath+"/pullrequests?state=OPEN&state=MERGED&state=DECLINED&state=SUPERSEDED")
+	if err != nil {
+		return fmt.Errorf("could not export pull requests: %w", err)
+	}
+	for _, pr := range prs {
+		id, err := exportedID(pr)
+		if err != nil {
+			return fmt.Errorf("could not read pull request: %w", err)
+		}
+		prPath := fmt.Sprintf("%s/pullrequests/%d", repoPath, id)
+		var exported exportedPullRequest
+		exported.PullRequest, err = bitbucketGet(config, prPath)
+		if err != nil {
+			return fmt.Errorf("could not export pull request %d: %w", id, err)
+		}
+		exported.Comments, err = bitbucketGetAll(config, prPath+"/comments")
+		if err != nil {
+			return fmt.Errorf("could not export comments of pull request %d: %w", id, err)
+		}
+		exported.Activity, err = bitbucketGetAll(config, prPath+"/activity")
+		if err != nil {
+			return fmt.Errorf("could not export activity of pull request %d: %w", id, err)
+		}
+		exported.Tasks, err = bitbucketGetAll(config, prPath+"/tasks")
+		if err != nil {
+			return fmt.Errorf("could not export tasks of pull request %d: %w", id, err)
+		}
+		err = writeJSONFile(filepath.Join(dir, exportPrsDir, fmt.Sprintf("%d.json", id)), exported)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Exporting issues")
+	issues, err := bitbucketGetAll(config, repoPath+"/issues")
+	if err != nil {
+		if err := unavailable("issues", err); err != nil {
+			return err
+		}
+	}
+	for _, issue := range issues {
+		id, err := exportedID(issue)
+		if err != nil {
+			return fmt.Errorf("could not read issue: %w", err)
+		}
+		exported := exportedIssue{Issue: issue}
+		exported.Comments, err = bitbucketGetAll(config, fmt.Sprintf("%s/issues/%d/comments", repoPath, id))
+		if err != nil {
+			return fmt.Errorf("could not export comments of issue %d: %w", id, err)
+		}
+		err = writeJSONFile(filepath.Join(dir, exportIssuesDir, fmt.Sprintf("%d.json", id)), exported)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Exporting settings")
+	restrictions, err := bitbucketGetAll(config, repoPath+"/branch-restrictions")
+	if err == nil {
+		err = writeJSONFile(filepath.Join(dir, "branch_restrictions.json"), restrictions)
+	} else {
+		err = unavailable("branch_restrictions", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	var permissions exportedPermissions
+	permissions.Users, err = bitbucketGetAll(config, repoPath+"/permissions-config/users")
+	if err != nil {
+		return fmt.Errorf("could not export user permissions: %w", err)
+	}
+	permissions.Groups, err = bitbucketGetAll(config, repoPath+"/permissions-config/groups")
+	if err != nil {
+		return fmt.Errorf("could not export group permissions: %w", err)
+	}
+	err = writeJSONFile(filepath.Join(dir, "permissions.json"), permissions)
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := bitbucketGetAll(config, repoPath+"/hooks")
+	if err == nil {
+		err = writeJSONFile(filepath.Join(dir, "webhooks.json"), webhooks)
+	} else {
+		err = unavailable("webhooks", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	variables, err := bitbucketGetAll(config, repoPath+"/pipelines_config/variables")
+	if err == nil {
+		var names []map[string]any
+		names, err = pipelineVariableNames(variables)
+		if err == nil {
+			err = writeJSONFile(filepath.Join(dir, "pipeline_variables.json"), names)
+		}
+	} else {
+		err = unavailable("pipeline_variables", err)
+	}
+	return err
+}
+
+// strips the values from pipeline variables, only their names are exported
+func pipelineVariableNames(variables []json.RawMessage) ([]map[string]any, error) {
+	names := []map[string]any{}
+	for _, variable := range variables {
+		var fields map[string]any
+		err := json.Unmarshal(variable, &fields)
+		if err != nil {
+			return nil, fmt.Errorf("could not read pipeline variable: %w", err)
+		}
+		names = append(names, map[string]any{
+			"key":     fields["key"],
+			"secured": fields["secured"],
+			"uuid":    fields["uuid"],
+		})
+	}
+	return names, nil
+}
+
+// the id of a PR, issue or comment from the api, an error if it has none
+func exportedID(raw json.RawMessage) (int, error) {
+	var fields struct {
+		ID int `json:"id"`
+	}
+	err := json.Unmarshal(raw, &fields)
+	if err == nil && fields.ID == 0 {
+		err = fmt.Errorf("no id in %s", excerpt(string(raw), 80))
+	}
+	return fields.ID, err
+}
+
+func writeJSONFile(path string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// writes every file in dir to a gzipped tarball, together with the manifest and a checksums file
+// that `sha256sum -c` can check after extracting
+func writeExportArchive(dir string, archive string, manifest exportManifest) error {
+	_, paths, err := listTree(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		file, err := checksumFile(dir, path)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+	err = writeJSONFile(filepath.Join(dir, exportManifestFile), manifest)
+	if err != nil {
+		return err
+	}
+	manifestFile, err := checksumFile(dir, exportManifestFile)
+	if err != nil {
+		return err
+	}
+	var checksums strings.Builder
+	for _, file := range append([]exportFile{manifestFile}, manifest.Files...) {
+		fmt.Fprintf(&checksums, "%s  %s\n", file.SHA256, file.Path)
+	}
+	err = os.WriteFile(filepath.Join(dir, exportChecksumsFile), []byte(checksums.String()), 0644)
+	if err != nil {
+		return err
+	}
+	return writeTarball(dir, archive, exportManifestFile, exportChecksumsFile)
+}
+
+// returns the directories and files under dir, relative to dir and sorted
+func listTree(dir string) (dirs []string, paths []string, err error) {
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.ToSlash(rel))
+		} else {
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	slices.Sort(dirs)
+	slices.Sort(paths)
+	return dirs, paths, err
+}
+
+// writes everything in dir to a gzipped tarball, starting with the first files.
+// directories are archived too because git needs the empty ones, eg refs/heads
+func writeTarball(dir string, archive string, first ...string) error {
+	dirs, paths, err := listTree(dir)
+	if err != nil {
+		return err
+	}
+	paths = slices.DeleteFunc(paths, func(path string) bool { return slices.Contains(first, path) })
+
+	out, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, path := range slices.Concat(first, dirs, paths) {
+		err = addToTar(tw, dir, path)
+		if err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func checksumFile(dir string, path string) (exportFile, error) {
+	f, err := os.Open(filepath.Join(dir, path))
+	if err != nil {
+		return exportFile{}, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return exportFile{}, err
+	}
+	return exportFile{Path: path, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+func addToTar(tw *tar.Writer, dir string, path string) error {
+	f, err := os.Open(filepath.Join(dir, path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = path
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	err = tw.WriteHeader(header)
+	if err != nil || info.IsDir() {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
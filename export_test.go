@@ -0,0 +1,120 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// returns the files in a gzipped tarball by name
+func readTarball(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		files[header.Name], err = io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExportRepo(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	bb.addRepo("my-repo", "My Repo", "PROJ")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	bb.addPr("my-repo", 2, "DECLINED", "old-branch", "")
+	bb.addPrComment("my-repo", 1, "looks good")
+	bb.pipelineVariables = []map[string]any{{"key": "DEPLOY_KEY", "value": "super-secret", "secured": false, "uuid": "{1}"}}
+
+	config := testSettings(t, bb, gh)
+	config.exportDir = t.TempDir()
+	if _, err := newBitbucketAPI(&config); err != nil {
+		t.Fatal(err)
+	}
+	exportRepos([]repoEntry{{name: "my-repo", slug: "my-repo"}}, config)
+
+	files := readTarball(t, filepath.Join(config.exportDir, "test-workspace-my-repo.tar.gz"))
+
+	var manifest exportManifest
+	if err := json.Unmarshal(files[exportManifestFile], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.FormatVersion != exportFormatVersion || manifest.Slug != "my-repo" {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+	if !slices.Equal(manifest.Unavailable, []string{"issues"}) {
+		t.Errorf("expected only issues to be unavailable, got %v", manifest.Unavailable)
+	}
+
+	// every file except the checksums file itself is listed and matches its checksum
+	checksums := strings.Split(strings.TrimSpace(string(files[exportChecksumsFile])), "\n")
+	if len(checksums) != len(files)-1 {
+		t.Errorf("%d checksums for %d files", len(checksums), len(files)-1)
+	}
+	for _, line := range checksums {
+		sum, path, _ := strings.Cut(line, "  ")
+		hash := sha256.Sum256(files[path])
+		if hex.EncodeToString(hash[:]) != sum {
+			t.Errorf("checksum of %s does not match", path)
+		}
+	}
+
+	for _, path := range []string{exportRepoFile, "repo.git/HEAD", "pull_requests/1.json", "pull_requests/2.json",
+		"branch_restrictions.json", "permissions.json", "webhooks.json", "pipeline_variables.json"} {
+		if _, ok := files[path]; !ok {
+			t.Errorf("%s is missing from the archive", path)
+		}
+	}
+	var pr exportedPullRequest
+	if err := json.Unmarshal(files["pull_requests/1.json"], &pr); err != nil {
+		t.Fatal(err)
+	}
+	if len(pr.Comments) != 1 || !strings.Contains(string(pr.Comments[0]), "looks good") {
+		t.Errorf("PR comments were not exported: %s", files["pull_requests/1.json"])
+	}
+	variables := string(files["pipeline_variables.json"])
+	if !strings.Contains(variables, "DEPLOY_KEY") || strings.Contains(variables, "super-secret") {
+		t.Errorf("pipeline variables should only contain names: %s", variables)
+	}
+}
+
+func TestExportedID(t *testing.T) {
+	id, err := exportedID(json.RawMessage(`{"id": 7, "title": "a PR"}`))
+	if err != nil || id != 7 {
+		t.Errorf("got %d, %v for a PR with id 7", id, err)
+	}
+	for _, raw := range []string{`{"title": "no id"}`, `{"id": "7"}`, `[]`} {
+		if _, err := exportedID(json.RawMessage(raw)); err == nil {
+			t.Errorf("no error for %s", raw)
+		}
+	}
+}
@@ -0,0 +1,919 @@
+package main
+
+// in-process fakes of the Bitbucket and Github APIs so whole migrations can run offline.
+// both fakes serve their git repos from local bare repos through git http-backend,
+// so cloning and pushing goes through real git over http with the real credentials
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const (
+	fakeWorkspace   = "test-workspace"
+	fakeBbUser      = "test-user"
+	fakeBbPassword  = "test-app-password"
+	fakeGithubOrg   = "test-org"
+	fakeGithubToken = "test-github-token"
+	// bitbucket's timestamp format
+	fakeTimestamp = "2024-05-01T10:00:00.000000+00:00"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %s\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// serves the bare repos under root over git's smart http protocol
+func gitBackend(t *testing.T, root string) http.Handler {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	return &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+}
+
+func createBareRepo(t *testing.T, path string) {
+	t.Helper()
+	runGit(t, filepath.Dir(path), "init", "--bare", "--initial-branch=main", path)
+	// let git http-backend accept pushes without REMOTE_USER
+	runGit(t, path, "config", "http.receivepack", "true")
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+type fakeBitbucket struct {
+	t       *testing.T
+	server  *httptest.Server
+	gitRoot string
+
+	mu       sync.Mutex
+	repos    map[string]map[string]any
+	prs      map[string][]map[string]any
+	comments map[string][]map[string]any
+	activity map[string][]map[string]any
+	tasks    map[string][]map[string]any
+	// files uploaded to PRs, by url path
+	attachments map[string][]byte
+	// pipeline variables are the same for every repo
+	pipelineVariables []map[string]any
+}
+
+func newFakeBitbucket(t *testing.T) *fakeBitbucket {
+	f := &fakeBitbucket{
+		t:        t,
+		gitRoot:  t.TempDir(),
+		repos:    map[string]map[string]any{},
+		prs:      map[string][]map[string]any{},
+		comments: map[string][]map[string]any{},
+		activity: map[string][]map[string]any{},
+		tasks:    map[string][]map[string]any{},
+
+		attachments: map[string][]byte{},
+	}
+	os.MkdirAll(filepath.Join(f.gitRoot, fakeWorkspace), 0755)
+	git := gitBackend(t, f.gitRoot)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /2.0/repositories/{workspace}", f.listRepos)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}", f.getRepo)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/pullrequests/", f.listPrs)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/pullrequests", f.listPrs)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/pullrequests/{id}", f.getPr)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/pullrequests/{id}/comments", f.listPrComments)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/pullrequests/{id}/activity", f.listPrActivity)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/pullrequests/{id}/tasks", f.listPrTasks)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/branch-restrictions", emptyPage)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/permissions-config/users", emptyPage)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/permissions-config/groups", emptyPage)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/hooks", emptyPage)
+	mux.HandleFunc("GET /2.0/repositories/{workspace}/{slug}/pipelines_config/variables", f.listPipelineVariables)
+	mux.HandleFunc("GET /repo/{id}/images/{name}", f.getAttachment)
+
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != fakeBbUser || password != fakeBbPassword {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Bitbucket"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if strings.Contains(r.URL.Path, ".git/") {
+			git.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// settings pointing at the fake, meant to be combined with fakeGithub.configure
+func (f *fakeBitbucket) configure(config *settings) {
+	config.bbWorkspace = fakeWorkspace
+	config.bbUsername = fakeBbUser
+	config.bbPassword = fakeBbPassword
+	config.bbAccessToken = ""
+	config.bbOAuthClientID = ""
+	config.bbOAuthClientSecret = ""
+	config.bbURL = f.server.URL
+	config.bbAPIURL = f.server.URL + "/2.0"
+	config.cloneVia = "https"
+}
+
+// adds a repo with a main branch, a feature branch and a tag.
+// returns the path of a working copy that pushes to the repo
+func (f *fakeBitbucket) addRepo(slug string, name string, projectKey string) string {
+	f.t.Helper()
+	bare := filepath.Join(f.gitRoot, fakeWorkspace, slug+".git")
+	createBareRepo(f.t, bare)
+
+	work := f.t.TempDir()
+	runGit(f.t, work, "init", "--initial-branch=main")
+	os.WriteFile(filepath.Join(work, "README.md"), []byte("# "+name+"\n"), 0644)
+	runGit(f.t, work, "add", "-A")
+	runGit(f.t, work, "commit", "-m", "initial commit")
+	runGit(f.t, work, "tag", "v1.0")
+	runGit(f.t, work, "checkout", "-b", "feature")
+	os.WriteFile(filepath.Join(work, "feature.txt"), []byte("feature\n"), 0644)
+	runGit(f.t, work, "add", "-A")
+	runGit(f.t, work, "commit", "-m", "add feature")
+	runGit(f.t, work, "checkout", "main")
+	runGit(f.t, work, "remote", "add", "origin", bare)
+	runGit(f.t, work, "push", "origin", "--all")
+	runGit(f.t, work, "push", "origin", "--tags")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.repos[slug] = map[string]any{
+		"type":        "repository",
+		"slug":        slug,
+		"name":        name,
+		"full_name":   fakeWorkspace + "/" + slug,
+		"description": "description of " + name,
+		"language":    "go",
+		"is_private":  true,
+		"mainbranch":  map[string]any{"name": "main", "type": "branch"},
+		"project":     map[string]any{"key": projectKey, "name": "Project " + projectKey},
+		"created_on":  fakeTimestamp,
+		"updated_on":  fakeTimestamp,
+	}
+	return work
+}
+
+// the source and destination commits are set when their branches exist
+func (f *fakeBitbucket) addPr(slug string, id int, state string, sourceBranch string, mergeCommit string) {
+	source := map[string]any{"branch": map[string]any{"name": sourceBranch}}
+	if hash := f.branchHash(slug, sourceBranch); hash != "" {
+		source["commit"] = map[string]any{"hash": hash}
+	}
+	destination := map[string]any{"branch": map[string]any{"name": "main"}}
+	if hash := f.branchHash(slug, "main"); hash != "" {
+		destination["commit"] = map[string]any{"hash": hash}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prs[slug] = append(f.prs[slug], map[string]any{
+		"type":        "pullrequest",
+		"id":          id,
+		"title":       fmt.Sprintf("PR number %d", id),
+		"state":       state,
+		"summary":     map[string]any{"raw": fmt.Sprintf("summary of PR %d", id)},
+		"author":      map[string]any{"display_name": "Test Author"},
+		"source":      source,
+		"destination": destination,
+		"merge_commit": map[string]any{
+			"hash": mergeCommit,
+		},
+		"created_on": fakeTimestamp,
+		"updated_on": fakeTimestamp,
+	})
+}
+
+// returns the short hash of the branch like bitbucket does, empty if it doesn't exist
+func (f *fakeBitbucket) branchHash(slug string, branch string) string {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "--short=12", "refs/heads/"+branch)
+	cmd.Dir = filepath.Join(f.gitRoot, fakeWorkspace, slug+".git")
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func (f *fakeBitbucket) addPrComment(slug string, prID int, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := fmt.Sprintf("%s/%d", slug, prID)
+	f.comments[key] = append(f.comments[key], map[string]any{
+		"id":         len(f.comments[key]) + 1,
+		"content":    map[string]any{"raw": text},
+		"user":       map[string]any{"display_name": "Test Commenter"},
+		"created_on": fakeTimestamp,
+	})
+}
+
+// records an approval of the PR by the user
+func (f *fakeBitbucket) addPrApproval(slug string, prID int, user map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := fmt.Sprintf("%s/%d", slug, prID)
+	f.activity[key] = append(f.activity[key], map[string]any{
+		"approval": map[string]any{"date": fakeTimestamp, "user": user},
+	})
+}
+
+// adds a task to the PR, created on the comment with commentID unless it is 0
+func (f *fakeBitbucket) addPrTask(slug string, prID int, text string, resolved bool, commentID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := fmt.Sprintf("%s/%d", slug, prID)
+	task := map[string]any{
+		"id":         len(f.tasks[key]) + 1,
+		"state":      "UNRESOLVED",
+		"content":    map[string]any{"raw": text},
+		"creator":    map[string]any{"display_name": "Test Reviewer"},
+		"created_on": fakeTimestamp,
+	}
+	if resolved {
+		task["state"] = "RESOLVED"
+	}
+	if commentID != 0 {
+		task["comment"] = map[string]any{"id": commentID}
+	}
+	f.tasks[key] = append(f.tasks[key], task)
+	for _, pr := range f.prs[slug] {
+		if pr["id"] == prID {
+			pr["task_count"] = len(f.tasks[key])
+		}
+	}
+}
+
+// uploads a file like an image pasted into a PR and returns its url
+func (f *fakeBitbucket) addAttachment(name string, content []byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := fmt.Sprintf("/repo/%d/images/%s", len(f.attachments)+1, name)
+	f.attachments[path] = content
+	return f.server.URL + path
+}
+
+func (f *fakeBitbucket) getAttachment(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.attachments[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(content)
+}
+
+func emptyPage(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"values": []any{}, "page": 1, "pagelen": 10, "size": 0})
+}
+
+func (f *fakeBitbucket) listRepos(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := []any{}
+	for _, repo := range f.repos {
+		values = append(values, repo)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"values": values, "page": 1, "pagelen": 100, "size": len(values)})
+}
+
+func (f *fakeBitbucket) getRepo(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("slug")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{"type": "error", "error": map[string]any{"message": "not found"}})
+		return
+	}
+	writeJSON(w, http.StatusOK, repo)
+}
+
+func (f *fakeBitbucket) listPrs(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := []any{}
+	for _, pr := range f.prs[r.PathValue("slug")] {
+		values = append(values, pr)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"values": values, "page": 1, "pagelen": 50, "size": len(values)})
+}
+
+func (f *fakeBitbucket) getPr(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, pr := range f.prs[r.PathValue("slug")] {
+		if fmt.Sprint(pr["id"]) == r.PathValue("id") {
+			writeJSON(w, http.StatusOK, pr)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]any{"type": "error", "error": map[string]any{"message": "not found"}})
+}
+
+func (f *fakeBitbucket) listPrComments(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := []any{}
+	for _, comment := range f.comments[r.PathValue("slug")+"/"+r.PathValue("id")] {
+		values = append(values, comment)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"values": values, "page": 1, "pagelen": 10, "size": len(values)})
+}
+
+func (f *fakeBitbucket) listPrActivity(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := []any{}
+	for _, entry := range f.activity[r.PathValue("slug")+"/"+r.PathValue("id")] {
+		values = append(values, entry)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"values": values, "page": 1, "pagelen": 10, "size": len(values)})
+}
+
+func (f *fakeBitbucket) listPrTasks(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := []any{}
+	for _, task := range f.tasks[r.PathValue("slug")+"/"+r.PathValue("id")] {
+		values = append(values, task)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"values": values, "page": 1, "pagelen": 10, "size": len(values)})
+}
+
+func (f *fakeBitbucket) listPipelineVariables(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := []any{}
+	for _, variable := range f.pipelineVariables {
+		values = append(values, variable)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"values": values, "page": 1, "pagelen": 10, "size": len(values)})
+}
+
+// an issue or pull request in the fake github
+type fakeIssue struct {
+	Number int
+	Title  string
+	Body   string
+	State  string
+	Labels []string
+	IsPR   bool
+	Head   string
+	Base   string
+	Draft  bool
+	Locked bool
+	// logins asked to review the PR
+	Reviewers []string
+	Comments  []string
+}
+
+type fakeGithubRepo struct {
+	repo           *github.Repository
+	topics         []string
+	properties     []*github.CustomPropertyValue
+	issues         []*fakeIssue
+	commitComments map[string][]string
+}
+
+type fakeGithub struct {
+	t       *testing.T
+	server  *httptest.Server
+	gitRoot string
+
+	mu    sync.Mutex
+	repos map[string]*fakeGithubRepo
+}
+
+func newFakeGithub(t *testing.T) *fakeGithub {
+	f := &fakeGithub{
+		t:       t,
+		gitRoot: t.TempDir(),
+		repos:   map[string]*fakeGithubRepo{},
+	}
+	os.MkdirAll(filepath.Join(f.gitRoot, fakeGithubOrg), 0755)
+	git := gitBackend(t, f.gitRoot)
+
+	// the fake looks like Github Enterprise Server, which serves the api under /api/v3
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v3/orgs/{org}/repos", f.createRepo)
+	mux.HandleFunc("GET /api/v3/repos/{org}/{repo}", f.getRepo)
+	mux.HandleFunc("PATCH /api/v3/repos/{org}/{repo}", f.editRepo)
+	mux.HandleFunc("PUT /api/v3/repos/{org}/{repo}/topics", f.replaceTopics)
+	mux.HandleFunc("PATCH /api/v3/repos/{org}/{repo}/properties/values", f.updateProperties)
+	mux.HandleFunc("POST /api/v3/repos/{org}/{repo}/pulls", f.createPull)
+	mux.HandleFunc("PATCH /api/v3/repos/{org}/{repo}/pulls/{number}", f.editIssue)
+	mux.HandleFunc("POST /api/v3/repos/{org}/{repo}/issues", f.createIssue)
+	mux.HandleFunc("PATCH /api/v3/repos/{org}/{repo}/issues/{number}", f.editIssue)
+	mux.HandleFunc("PUT /api/v3/repos/{org}/{repo}/issues/{number}/lock", f.lockIssue)
+	mux.HandleFunc("POST /api/v3/repos/{org}/{repo}/issues/{number}/comments", f.createIssueComment)
+	mux.HandleFunc("POST /api/v3/repos/{org}/{repo}/pulls/{number}/requested_reviewers", f.requestReviewers)
+	mux.HandleFunc("POST /api/v3/repos/{org}/{repo}/commits/{sha}/comments", f.createCommitComment)
+	mux.HandleFunc("GET /api/v3/repos/{org}/{repo}/git/ref/{ref...}", f.getGitRef)
+	mux.HandleFunc("GET /api/v3/repos/{org}/{repo}/git/commits/{sha}", f.getGitCommit)
+	mux.HandleFunc("POST /api/v3/repos/{org}/{repo}/git/blobs", f.createGitBlob)
+	mux.HandleFunc("POST /api/v3/repos/{org}/{repo}/git/trees", f.createGitTree)
+	mux.HandleFunc("POST /api/v3/repos/{org}/{repo}/git/commits", f.createGitCommit)
+	mux.HandleFunc("POST /api/v3/repos/{org}/{repo}/git/refs", f.createGitRef)
+	mux.HandleFunc("PATCH /api/v3/repos/{org}/{repo}/git/refs/{ref...}", f.updateGitRef)
+
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, ".git/") {
+			user, password, ok := r.BasicAuth()
+			if !ok || user != "x-access-token" || password != fakeGithubToken {
+				w.Header().Set("WWW-Authenticate", `Basic realm="GitHub"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			git.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+fakeGithubToken {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"message": "Bad credentials"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// settings pointing at the fake, meant to be combined with fakeBitbucket.configure
+func (f *fakeGithub) configure(config *settings) {
+	config.ghOrg = fakeGithubOrg
+	config.ghToken = fakeGithubToken
+	config.ghBaseURL = f.server.URL
+	config.ghUploadURL = ""
+	config.ghAppID = ""
+	config.ghAppPrivateKey = ""
+	config.ghAppInstallationID = ""
+}
+
+// returns the repo or nil if it wasn't created
+func (f *fakeGithub) repo(name string) *fakeGithubRepo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.repos[name]
+}
+
+func (f *fakeGithub) notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]any{"message": "Not Found"})
+}
+
+func (f *fakeGithub) decode(w http.ResponseWriter, r *http.Request, body any) bool {
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error()})
+		return false
+	}
+	return true
+}
+
+func (f *fakeGithub) createRepo(w http.ResponseWriter, r *http.Request) {
+	var repo github.Repository
+	if !f.decode(w, r, &repo) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := repo.GetName()
+	if _, ok := f.repos[name]; ok {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"message": "Repository creation failed.",
+			"errors":  []map[string]any{{"resource": "Repository", "code": "custom", "field": "name", "message": "name already exists on this account"}},
+		})
+		return
+	}
+	createBareRepo(f.t, filepath.Join(f.gitRoot, fakeGithubOrg, name+".git"))
+	repo.FullName = github.Ptr(fakeGithubOrg + "/" + name)
+	f.repos[name] = &fakeGithubRepo{repo: &repo, commitComments: map[string][]string{}}
+	writeJSON(w, http.StatusCreated, repo)
+}
+
+func (f *fakeGithub) getRepo(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	if !ok {
+		f.notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, repo.repo)
+}
+
+func (f *fakeGithub) editRepo(w http.ResponseWriter, r *http.Request) {
+	var edit github.Repository
+	if !f.decode(w, r, &edit) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	if !ok {
+		f.notFound(w)
+		return
+	}
+	if edit.DefaultBranch != nil {
+		repo.repo.DefaultBranch = edit.DefaultBranch
+	}
+	if edit.Description != nil {
+		repo.repo.Description = edit.Description
+	}
+	writeJSON(w, http.StatusOK, repo.repo)
+}
+
+func (f *fakeGithub) replaceTopics(w http.ResponseWriter, r *http.Request) {
+	var topics struct {
+		Names []string `json:"names"`
+	}
+	if !f.decode(w, r, &topics) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	if !ok {
+		f.notFound(w)
+		return
+	}
+	repo.topics = topics.Names
+	writeJSON(w, http.StatusOK, topics)
+}
+
+func (f *fakeGithub) updateProperties(w http.ResponseWriter, r *http.Request) {
+	var properties struct {
+		Properties []*github.CustomPropertyValue `json:"properties"`
+	}
+	if !f.decode(w, r, &properties) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	if !ok {
+		f.notFound(w)
+		return
+	}
+	repo.properties = properties.Properties
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// returns whether head has commits that base doesn't, Github refuses PRs without any
+func (f *fakeGithub) hasCommitsBetween(repo string, base string, head string) bool {
+	cmd := exec.Command("git", "rev-list", "--count", "refs/heads/"+base+"..refs/heads/"+head)
+	cmd.Dir = filepath.Join(f.gitRoot, fakeGithubOrg, repo+".git")
+	output, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(output)) != "0"
+}
+
+// returns whether branch exists in the repo's bare git repo
+func (f *fakeGithub) branchExists(repo string, branch string) bool {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	cmd.Dir = filepath.Join(f.gitRoot, fakeGithubOrg, repo+".git")
+	return cmd.Run() == nil
+}
+
+// issues and pull requests share numbers, like in Github
+func (f *fakeGithub) addIssue(repo *fakeGithubRepo, issue *fakeIssue) {
+	issue.Number = len(repo.issues) + 1
+	issue.State = "open"
+	repo.issues = append(repo.issues, issue)
+}
+
+func (issue *fakeIssue) toGithub() map[string]any {
+	return map[string]any{
+		"number": issue.Number,
+		"title":  issue.Title,
+		"body":   issue.Body,
+		"state":  issue.State,
+		"url":    fmt.Sprintf("https://example.com/issues/%d", issue.Number),
+	}
+}
+
+func (f *fakeGithub) createPull(w http.ResponseWriter, r *http.Request) {
+	var pull github.NewPullRequest
+	if !f.decode(w, r, &pull) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	if !ok {
+		f.notFound(w)
+		return
+	}
+	if !f.branchExists(r.PathValue("repo"), pull.GetHead()) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"message": "Validation Failed",
+			"errors":  []map[string]any{{"resource": "PullRequest", "field": "head", "code": "invalid"}},
+		})
+		return
+	}
+	if !f.hasCommitsBetween(r.PathValue("repo"), pull.GetBase(), pull.GetHead()) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"message": "Validation Failed",
+			"errors": []map[string]any{{"resource": "PullRequest", "code": "custom",
+				"message": fmt.Sprintf("No commits between %s and %s", pull.GetBase(), pull.GetHead())}},
+		})
+		return
+	}
+	issue := &fakeIssue{
+		Title: pull.GetTitle(),
+		Body:  pull.GetBody(),
+		IsPR:  true,
+		Head:  pull.GetHead(),
+		Base:  pull.GetBase(),
+		Draft: pull.GetDraft(),
+	}
+	f.addIssue(repo, issue)
+	writeJSON(w, http.StatusCreated, issue.toGithub())
+}
+
+func (f *fakeGithub) createIssue(w http.ResponseWriter, r *http.Request) {
+	var request github.IssueRequest
+	if !f.decode(w, r, &request) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	if !ok {
+		f.notFound(w)
+		return
+	}
+	issue := &fakeIssue{Title: request.GetTitle(), Body: request.GetBody()}
+	if request.Labels != nil {
+		issue.Labels = *request.Labels
+	}
+	f.addIssue(repo, issue)
+	writeJSON(w, http.StatusCreated, issue.toGithub())
+}
+
+func (f *fakeGithub) editIssue(w http.ResponseWriter, r *http.Request) {
+	var request github.IssueRequest
+	if !f.decode(w, r, &request) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if !ok || err != nil || number < 1 || number > len(repo.issues) {
+		f.notFound(w)
+		return
+	}
+	issue := repo.issues[number-1]
+	if request.State != nil {
+		issue.State = request.GetState()
+	}
+	if request.Title != nil {
+		issue.Title = request.GetTitle()
+	}
+	if request.Body != nil {
+		issue.Body = request.GetBody()
+	}
+	writeJSON(w, http.StatusOK, issue.toGithub())
+}
+
+func (f *fakeGithub) lockIssue(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if !ok || err != nil || number < 1 || number > len(repo.issues) {
+		f.notFound(w)
+		return
+	}
+	repo.issues[number-1].Locked = true
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeGithub) createIssueComment(w http.ResponseWriter, r *http.Request) {
+	var comment github.IssueComment
+	if !f.decode(w, r, &comment) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if !ok || err != nil || number < 1 || number > len(repo.issues) {
+		f.notFound(w)
+		return
+	}
+	issue := repo.issues[number-1]
+	issue.Comments = append(issue.Comments, comment.GetBody())
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+func (f *fakeGithub) requestReviewers(w http.ResponseWriter, r *http.Request) {
+	var request github.ReviewersRequest
+	if !f.decode(w, r, &request) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if !ok || err != nil || number < 1 || number > len(repo.issues) || !repo.issues[number-1].IsPR {
+		f.notFound(w)
+		return
+	}
+	issue := repo.issues[number-1]
+	issue.Reviewers = append(issue.Reviewers, request.Reviewers...)
+	writeJSON(w, http.StatusCreated, issue.toGithub())
+}
+
+func (f *fakeGithub) createCommitComment(w http.ResponseWriter, r *http.Request) {
+	var comment github.RepositoryComment
+	if !f.decode(w, r, &comment) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo, ok := f.repos[r.PathValue("repo")]
+	if !ok {
+		f.notFound(w)
+		return
+	}
+	sha := r.PathValue("sha")
+	repo.commitComments[sha] = append(repo.commitComments[sha], comment.GetBody())
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+// runs git in the bare repo the git data api of the fake works on
+func (f *fakeGithub) git(r *http.Request, env []string, stdin []byte, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = filepath.Join(f.gitRoot, fakeGithubOrg, r.PathValue("repo")+".git")
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env,
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	if stdin != nil {
+		cmd.Stdin = strings.NewReader(string(stdin))
+	}
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+func (f *fakeGithub) gitError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"message": err.Error()})
+}
+
+func (f *fakeGithub) getGitRef(w http.ResponseWriter, r *http.Request) {
+	ref := "refs/" + r.PathValue("ref")
+	sha, err := f.git(r, nil, nil, "rev-parse", "--verify", "--quiet", ref)
+	if err != nil {
+		f.notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ref": ref, "object": map[string]any{"sha": sha, "type": "commit"}})
+}
+
+func (f *fakeGithub) getGitCommit(w http.ResponseWriter, r *http.Request) {
+	tree, err := f.git(r, nil, nil, "rev-parse", "--verify", "--quiet", r.PathValue("sha")+"^{tree}")
+	if err != nil {
+		f.notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sha": r.PathValue("sha"), "tree": map[string]any{"sha": tree}})
+}
+
+func (f *fakeGithub) createGitBlob(w http.ResponseWriter, r *http.Request) {
+	var blob github.Blob
+	if !f.decode(w, r, &blob) {
+		return
+	}
+	if refs, _ := f.git(r, nil, nil, "for-each-ref"); refs == "" {
+		writeJSON(w, http.StatusConflict, map[string]any{"message": "Git Repository is empty."})
+		return
+	}
+	content, err := base64.StdEncoding.DecodeString(blob.GetContent())
+	if err != nil {
+		f.gitError(w, err)
+		return
+	}
+	sha, err := f.git(r, nil, content, "hash-object", "-w", "--stdin")
+	if err != nil {
+		f.gitError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"sha": sha})
+}
+
+func (f *fakeGithub) createGitTree(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		BaseTree string              `json:"base_tree"`
+		Tree     []*github.TreeEntry `json:"tree"`
+	}
+	if !f.decode(w, r, &request) {
+		return
+	}
+	index := []string{"GIT_INDEX_FILE=" + filepath.Join(f.t.TempDir(), "index")}
+	if request.BaseTree != "" {
+		if _, err := f.git(r, index, nil, "read-tree", request.BaseTree); err != nil {
+			f.gitError(w, err)
+			return
+		}
+	}
+	for _, entry := range request.Tree {
+		_, err := f.git(r, index, nil, "update-index", "--add", "--cacheinfo", entry.GetMode()+","+entry.GetSHA()+","+entry.GetPath())
+		if err != nil {
+			f.gitError(w, err)
+			return
+		}
+	}
+	sha, err := f.git(r, index, nil, "write-tree")
+	if err != nil {
+		f.gitError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"sha": sha})
+}
+
+func (f *fakeGithub) createGitCommit(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Message string   `json:"message"`
+		Tree    string   `json:"tree"`
+		Parents []string `json:"parents"`
+	}
+	if !f.decode(w, r, &request) {
+		return
+	}
+	args := []string{"commit-tree", request.Tree, "-m", request.Message}
+	for _, parent := range request.Parents {
+		args = append(args, "-p", parent)
+	}
+	sha, err := f.git(r, nil, nil, args...)
+	if err != nil {
+		f.gitError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"sha": sha, "tree": map[string]any{"sha": request.Tree}})
+}
+
+func (f *fakeGithub) createGitRef(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	}
+	if !f.decode(w, r, &request) {
+		return
+	}
+	// the empty old value makes update-ref fail if the ref already exists, like Github
+	if _, err := f.git(r, nil, nil, "update-ref", request.Ref, request.SHA, ""); err != nil {
+		f.gitError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"ref": request.Ref, "object": map[string]any{"sha": request.SHA}})
+}
+
+func (f *fakeGithub) updateGitRef(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		SHA string `json:"sha"`
+	}
+	if !f.decode(w, r, &request) {
+		return
+	}
+	ref := "refs/" + r.PathValue("ref")
+	if _, err := f.git(r, nil, nil, "update-ref", ref, request.SHA); err != nil {
+		f.gitError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ref": ref, "object": map[string]any{"sha": request.SHA}})
+}
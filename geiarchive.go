@@ -0,0 +1,548 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXPORT_FORMAT=github turns the export into a Github migration archive, the format Github's own
+// migration tools import. unlike the REST api it keeps the authors, reviews and timestamps of PRs
+
+// the version of the migration archive schema we write
+const githubMigrationSchemaVersion = "1.2.0"
+
+// how many records go in one json file of the archive, eg pull_requests_000001.json
+const githubMigrationFileSize = 100
+
+// review states as they are stored in migration archives
+const (
+	githubReviewCommented        = 1
+	githubReviewChangesRequested = 30
+	githubReviewApproved         = 40
+)
+
+var invalidGithubLoginChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// converts an export staged in dir, laid out like an export archive, to a Github migration archive
+func writeGithubMigrationArchive(dir string, archive string, ghName string, config settings) error {
+	out, err := os.MkdirTemp("", "btg-github-archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(out)
+
+	var repo map[string]any
+	err = readArchiveJSON(dir, exportRepoFile, &repo)
+	if err != nil {
+		return err
+	}
+	converter := newMigrationConverter(filepath.Join(dir, exportMirrorDir), ghName, config)
+	repositories := []map[string]any{converter.repository(repo)}
+
+	paths, err := filepath.Glob(filepath.Join(dir, exportPrsDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	// numeric order, so 2.json comes before 10.json
+	slices.SortFunc(paths, func(a, b string) int {
+		return prNumberFromPath(a) - prNumberFromPath(b)
+	})
+	for _, path := range paths {
+		var pr exportedPullRequest
+		err = readArchiveJSON(dir, filepath.Join(exportPrsDir, filepath.Base(path)), &pr)
+		if err != nil {
+			return err
+		}
+		err = converter.addPullRequest(pr)
+		if err != nil {
+			return err
+		}
+	}
+
+	files := map[string][]map[string]any{
+		"organizations":                converter.organizations(),
+		"repositories":                 repositories,
+		"users":                        converter.userList(),
+		"pull_requests":                converter.pullRequests,
+		"pull_request_reviews":         converter.reviews,
+		"pull_request_review_comments": converter.reviewComments,
+		"issue_comments":               converter.issueComments,
+	}
+	for kind, records := range files {
+		err = writeMigrationJSON(out, kind, records)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeJSONFile(filepath.Join(out, "schema.json"), map[string]string{"version": githubMigrationSchemaVersion})
+	if err != nil {
+		return err
+	}
+
+	gitDir := filepath.Join(out, "repositories", config.ghOrg)
+	err = os.MkdirAll(gitDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(filepath.Join(dir, exportMirrorDir), filepath.Join(gitDir, ghName+".git"))
+	if err != nil {
+		return err
+	}
+	return writeTarball(out, archive, "schema.json")
+}
+
+func prNumberFromPath(path string) int {
+	number, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".json"))
+	return number
+}
+
+// writes records to kind_000001.json, kind_000002.json and so on
+func writeMigrationJSON(dir string, kind string, records []map[string]any) error {
+	for i, chunk := range slices.Collect(slices.Chunk(records, githubMigrationFileSize)) {
+		err := writeJSONFile(filepath.Join(dir, fmt.Sprintf("%s_%06d.json", kind, i+1)), chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// turns bitbucket api json into migration archive records.
+// records point at each other with their Github urls
+type migrationConverter struct {
+	mirror  string
+	org     string
+	name    string
+	webURL  string
+	orgURL  string
+	repoURL string
+	now     string
+	// converts the bodies of PRs and comments
+	markdown *markdownConverter
+	// users in USER_MAP_FILE keep their github login in the archive
+	logins userMap
+
+	users          map[string]map[string]any
+	pullRequests   []map[string]any
+	reviews        []map[string]any
+	reviewComments []map[string]any
+	issueComments  []map[string]any
+}
+
+func newMigrationConverter(mirror string, ghName string, config settings) *migrationConverter {
+	webURL := githubWebURL(config)
+	orgURL := webURL + "/" + config.ghOrg
+	markdown := newMarkdownConverter(config)
+	return &migrationConverter{
+		mirror:  mirror,
+		org:     config.ghOrg,
+		name:    ghName,
+		webURL:  webURL,
+		orgURL:  orgURL,
+		repoURL: orgURL + "/" + ghName,
+		now:     time.Now().UTC().Format(time.RFC3339),
+
+		markdown: markdown,
+		logins:   markdown.users,
+		users:    map[string]map[string]any{},
+	}
+}
+
+func (c *migrationConverter) organizations() []map[string]any {
+	return []map[string]any{{
+		"type":        "organization",
+		"url":         c.orgURL,
+		"login":       c.org,
+		"name":        c.org,
+		"description": nil,
+		"website":     nil,
+		"location":    nil,
+		"email":       nil,
+		"members":     []any{},
+	}}
+}
+
+func (c *migrationConverter) repository(repo map[string]any) map[string]any {
+	private, _ := repo["is_private"].(bool)
+	hasIssues, _ := repo["has_issues"].(bool)
+	hasWiki, _ := repo["has_wiki"].(bool)
+	return map[string]any{
+		"type":           "repository",
+		"url":            c.repoURL,
+		"owner":          c.orgURL,
+		"name":           c.name,
+		"description":    jsonString(repo, "description"),
+		"website":        nil,
+		"private":        private,
+		"public":         !private,
+		"has_issues":     hasIssues,
+		"has_wiki":       hasWiki,
+		"has_downloads":  true,
+		"labels":         []any{},
+		"collaborators":  []any{},
+		"webhooks":       []any{},
+		"created_at":     githubTime(jsonString(repo, "created_on")),
+		"git_url":        fmt.Sprintf("tarball://root/repositories/%s/%s.git", c.org, c.name),
+		"wiki_url":       nil,
+		"default_branch": jsonString(repo, "mainbranch", "name"),
+	}
+}
+
+// returns the url of the user, adding them to the users file. mapped users get their github login,
+// for the rest bitbucket nicknames become the logins and Github shows them as mannequins until they are reclaimed
+func (c *migrationConverter) userURL(user any) any {
+	account, ok := user.(map[string]any)
+	if !ok {
+		return nil
+	}
+	login := c.logins.login(account)
+	if login == "" {
+		login = invalidGithubLoginChars.ReplaceAllString(jsonString(account, "nickname"), "-")
+	}
+	if login == "" {
+		login = invalidGithubLoginChars.ReplaceAllString(jsonString(account, "display_name"), "-")
+	}
+	login = strings.Trim(login, "-")
+	if login == "" {
+		return nil
+	}
+	url := c.webURL + "/" + login
+	if _, ok := c.users[login]; !ok {
+		c.users[login] = map[string]any{
+			"type":         "user",
+			"url":          url,
+			"login":        login,
+			"name":         jsonString(account, "display_name"),
+			"company":      nil,
+			"website":      nil,
+			"location":     nil,
+			"emails":       []any{},
+			"billing_plan": nil,
+			"created_at":   c.now,
+		}
+	}
+	return url
+}
+
+func (c *migrationConverter) userList() []map[string]any {
+	var logins []string
+	for login := range c.users {
+		logins = append(logins, login)
+	}
+	slices.Sort(logins)
+	var users []map[string]any
+	for _, login := range logins {
+		users = append(users, c.users[login])
+	}
+	return users
+}
+
+func (c *migrationConverter) addPullRequest(exported exportedPullRequest) error {
+	var pr map[string]any
+	err := json.Unmarshal(exported.PullRequest, &pr)
+	if err != nil {
+		return err
+	}
+	id, err := exportedID(exported.PullRequest)
+	if err != nil {
+		return fmt.Errorf("could not read pull request: %w", err)
+	}
+	prURL := fmt.Sprintf("%s/pull/%d", c.repoURL, id)
+	baseSha := c.resolveCommit(jsonString(pr, "destination", "commit", "hash"), jsonString(pr, "destination", "branch", "name"))
+	headSha := c.resolveCommit(jsonString(pr, "source", "commit", "hash"), jsonString(pr, "source", "branch", "name"))
+	if baseSha == "" || headSha == "" {
+		fmt.Printf("Skipping PR %d, its commits are not in the repo\n", id)
+		return nil
+	}
+
+	var mergedAt, closedAt any
+	switch jsonString(pr, "state") {
+	case "MERGED":
+		mergedAt = c.mergedAt(pr)
+		closedAt = mergedAt
+	case "DECLINED", "SUPERSEDED":
+		closedAt = githubTime(jsonString(pr, "updated_on"))
+	}
+	err = c.addUsers(pr, exported.Comments)
+	if err != nil {
+		return err
+	}
+	draft, _ := pr["draft"].(bool)
+	body := jsonString(pr, "description")
+	if body == "" {
+		body = jsonString(pr, "summary", "raw")
+	}
+	c.pullRequests = append(c.pullRequests, map[string]any{
+		"type":                   "pull_request",
+		"url":                    prURL,
+		"user":                   c.userURL(pr["author"]),
+		"repository":             c.repoURL,
+		"title":                  jsonString(pr, "title"),
+		"body":                   c.markdown.convert(body),
+		"base":                   map[string]any{"ref": jsonString(pr, "destination", "branch", "name"), "sha": baseSha, "user": c.orgURL, "repo": c.repoURL},
+		"head":                   map[string]any{"ref": jsonString(pr, "source", "branch", "name"), "sha": headSha, "user": c.orgURL, "repo": c.repoURL},
+		"assignee":               nil,
+		"assignees":              []any{},
+		"milestone":              nil,
+		"labels":                 []any{},
+		"reactions":              []any{},
+		"review_requests":        []any{},
+		"close_issue_references": []any{},
+		"work_in_progress":       draft,
+		"merged_at":              mergedAt,
+		"closed_at":              closedAt,
+		"created_at":             githubTime(jsonString(pr, "created_on")),
+	})
+
+	participants, _ := pr["participants"].([]any)
+	for _, participant := range participants {
+		participant, _ := participant.(map[string]any)
+		participatedOn := githubTime(jsonString(participant, "participated_on"))
+		if approved, _ := participant["approved"].(bool); approved {
+			c.addReview(prURL, participant["user"], headSha, githubReviewApproved, participatedOn)
+		} else if jsonString(participant, "state") == "changes_requested" {
+			c.addReview(prURL, participant["user"], headSha, githubReviewChangesRequested, participatedOn)
+		}
+	}
+
+	for _, raw := range exported.Comments {
+		var comment map[string]any
+		err := json.Unmarshal(raw, &comment)
+		if err != nil {
+			return err
+		}
+		if deleted, _ := comment["deleted"].(bool); deleted {
+			continue
+		}
+		commentID, err := exportedID(raw)
+		if err != nil {
+			return fmt.Errorf("could not read comment of pull request %d: %w", id, err)
+		}
+		c.addComment(prURL, commentID, comment, baseSha, headSha)
+	}
+	return nil
+}
+
+// lets mentions of the author, reviewers, participants and commenters of the PR show their names
+func (c *migrationConverter) addUsers(pr map[string]any, comments []json.RawMessage) error {
+	author, _ := pr["author"].(map[string]any)
+	c.markdown.addUsers(author)
+	reviewers, _ := pr["reviewers"].([]any)
+	participants, _ := pr["participants"].([]any)
+	for _, reviewer := range reviewers {
+		reviewer, _ := reviewer.(map[string]any)
+		c.markdown.addUsers(reviewer)
+	}
+	for _, participant := range participants {
+		participant, _ := participant.(map[string]any)
+		user, _ := participant["user"].(map[string]any)
+		c.markdown.addUsers(user)
+	}
+	for _, raw := range comments {
+		var comment struct {
+			User map[string]any `json:"user"`
+		}
+		err := json.Unmarshal(raw, &comment)
+		if err != nil {
+			return err
+		}
+		c.markdown.addUsers(comment.User)
+	}
+	return nil
+}
+
+func (c *migrationConverter) addReview(prURL string, user any, headSha string, state int, createdAt any) string {
+	url := fmt.Sprintf("%s/files#pullrequestreview-%d", prURL, len(c.reviews)+1)
+	c.reviews = append(c.reviews, map[string]any{
+		"type":         "pull_request_review",
+		"url":          url,
+		"pull_request": prURL,
+		"user":         c.userURL(user),
+		"body":         "",
+		"head_sha":     headSha,
+		"formatter":    "markdown",
+		"state":        state,
+		"reactions":    []any{},
+		"created_at":   createdAt,
+		"submitted_at": createdAt,
+	})
+	return url
+}
+
+// inline comments become review comments when their line is in the PR's diff, everything else becomes an issue comment
+func (c *migrationConverter) addComment(prURL string, id int, comment map[string]any, baseSha string, headSha string) {
+	body := c.markdown.convert(jsonString(comment, "content", "raw"))
+	createdAt := githubTime(jsonString(comment, "created_on"))
+
+	if inline, ok := comment["inline"].(map[string]any); ok {
+		path := jsonString(inline, "path")
+		line, oldSide := inlineLine(inline)
+		position, hunk, found := c.diffPosition(baseSha, headSha, path, line, oldSide)
+		if found {
+			var inReplyTo any
+			if parentID := jsonString(comment, "parent", "id"); parentID != "" {
+				inReplyTo = fmt.Sprintf("%s/files#r%s", prURL, parentID)
+			}
+			c.reviewComments = append(c.reviewComments, map[string]any{
+				"type":                "pull_request_review_comment",
+				"url":                 fmt.Sprintf("%s/files#r%d", prURL, id),
+				"pull_request":        prURL,
+				"pull_request_review": c.addReview(prURL, comment["user"], headSha, githubReviewCommented, createdAt),
+				"user":                c.userURL(comment["user"]),
+				"body":                body,
+				"formatter":           "markdown",
+				"path":                path,
+				"position":            position,
+				"original_position":   position,
+				"commit_id":           headSha,
+				"original_commit_id":  headSha,
+				"diff_hunk":           hunk,
+				"state":               githubReviewCommented,
+				"in_reply_to":         inReplyTo,
+				"reactions":           []any{},
+				"created_at":          createdAt,
+			})
+			return
+		}
+		body = fmt.Sprintf("> on `%s` line %d\n\n%s", path, line, body)
+	}
+
+	c.issueComments = append(c.issueComments, map[string]any{
+		"type":         "issue_comment",
+		"url":          fmt.Sprintf("%s#issuecomment-%d", prURL, id),
+		"pull_request": prURL,
+		"user":         c.userURL(comment["user"]),
+		"body":         body,
+		"formatter":    "markdown",
+		"reactions":    []any{},
+		"created_at":   createdAt,
+	})
+}
+
+// returns the line an inline comment is on. bitbucket sets to for new and unchanged lines,
+// and only from for removed lines
+func inlineLine(inline map[string]any) (line int, oldSide bool) {
+	if to, ok := inline["to"].(float64); ok {
+		return int(to), false
+	}
+	from, _ := inline["from"].(float64)
+	return int(from), true
+}
+
+// returns the full hash of commit, or of branch if commit isn't in the mirror. empty if neither is
+func (c *migrationConverter) resolveCommit(commit string, branch string) string {
+	for _, rev := range []string{commit, "refs/heads/" + branch} {
+		if rev == "" || rev == "refs/heads/" {
+			continue
+		}
+		if hash, ok := resolveCommit(c.mirror, rev); ok {
+			return hash
+		}
+	}
+	return ""
+}
+
+// when the merge commit of the PR was committed. bitbucket doesn't say when a PR was merged,
+// so without the merge commit in the mirror it's when the PR was last updated, which is usually the merge
+func (c *migrationConverter) mergedAt(pr map[string]any) any {
+	if hash, ok := resolveCommit(c.mirror, jsonString(pr, "merge_commit", "hash")); ok {
+		cmd := exec.Command("git", "show", "-s", "--format=%cI", hash)
+		cmd.Dir = c.mirror
+		if output, err := cmd.Output(); err == nil {
+			return githubTime(strings.TrimSpace(string(output)))
+		}
+	}
+	return githubTime(jsonString(pr, "updated_on"))
+}
+
+// returns the position of line in the diff of path, the way Github counts it, and the diff hunk up to the line
+func (c *migrationConverter) diffPosition(baseSha string, headSha string, path string, line int, oldSide bool) (int, string, bool) {
+	cmd := exec.Command("git", "diff", baseSha+"..."+headSha, "--", path)
+	cmd.Dir = c.mirror
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, "", false
+	}
+	return findDiffPosition(string(output), line, oldSide)
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// the line below the first hunk header is position 1, and positions keep counting through later hunk headers
+func findDiffPosition(diff string, line int, oldSide bool) (int, string, bool) {
+	lines := strings.Split(diff, "\n")
+	position := 0
+	hunkStart := -1
+	var oldLine, newLine int
+	for i, text := range lines {
+		if match := hunkHeader.FindStringSubmatch(text); match != nil {
+			if hunkStart >= 0 {
+				position++
+			}
+			hunkStart = i
+			oldLine, _ = strconv.Atoi(match[1])
+			newLine, _ = strconv.Atoi(match[2])
+			continue
+		}
+		if hunkStart < 0 || text == "" || strings.HasPrefix(text, `\`) {
+			continue
+		}
+		position++
+		found := false
+		switch text[0] {
+		case ' ':
+			found = (oldSide && oldLine == line) || (!oldSide && newLine == line)
+			oldLine++
+			newLine++
+		case '-':
+			found = oldSide && oldLine == line
+			oldLine++
+		case '+':
+			found = !oldSide && newLine == line
+			newLine++
+		}
+		if found {
+			return position, strings.Join(lines[hunkStart:i+1], "\n"), true
+		}
+	}
+	return 0, "", false
+}
+
+// returns the string at path in value, or "" if there isn't one
+func jsonString(value map[string]any, path ...string) string {
+	var current any = value
+	for _, key := range path {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return ""
+		}
+		current = object[key]
+	}
+	switch current := current.(type) {
+	case string:
+		return current
+	case float64:
+		return strconv.FormatFloat(current, 'f', -1, 64)
+	}
+	return ""
+}
+
+// converts a bitbucket timestamp to the format Github uses, nil if there is none
+func githubTime(timestamp string) any {
+	if timestamp == "" {
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return parsed.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportGithubMigrationArchive(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	// its branch is gone, so it can't be in the archive
+	bb.addPr("my-repo", 2, "DECLINED", "old-branch", "")
+	mergeCommit := runGit(t, work, "rev-parse", "main")
+	bb.addPr("my-repo", 3, "MERGED", "feature", mergeCommit)
+	bb.prs["my-repo"][0]["participants"] = []any{
+		map[string]any{"user": map[string]any{"display_name": "Test Reviewer", "nickname": "reviewer"}, "state": "changes_requested", "participated_on": fakeTimestamp},
+	}
+	bb.addPrComment("my-repo", 1, "looks good")
+	bb.comments["my-repo/1"] = append(bb.comments["my-repo/1"], map[string]any{
+		"id":         2,
+		"content":    map[string]any{"raw": "typo here"},
+		"user":       map[string]any{"display_name": "Test Reviewer", "nickname": "reviewer"},
+		"inline":     map[string]any{"path": "feature.txt", "to": 1},
+		"created_on": fakeTimestamp,
+	})
+
+	config := testSettings(t, bb, gh)
+	config.exportDir = t.TempDir()
+	config.exportFormat = "github"
+	config.userMapFile = filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(config.userMapFile, []byte("bitbucket,github\nreviewer,jane-doe\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newBitbucketAPI(&config); err != nil {
+		t.Fatal(err)
+	}
+	exportRepos([]repoEntry{{name: "my-repo", slug: "my-repo"}}, config)
+
+	files := readTarball(t, filepath.Join(config.exportDir, "test-workspace-my-repo.tar.gz"))
+	if string(files["schema.json"]) != "{\n  \"version\": \"1.2.0\"\n}\n" {
+		t.Errorf("unexpected schema.json: %s", files["schema.json"])
+	}
+	if _, ok := files["repositories/"+config.ghOrg+"/my-repo.git/HEAD"]; !ok {
+		t.Errorf("git repo is missing from the archive")
+	}
+
+	var repos, prs, reviews, issueComments, reviewComments, users []map[string]any
+	for name, records := range map[string]*[]map[string]any{
+		"repositories_000001.json":                 &repos,
+		"pull_requests_000001.json":                &prs,
+		"pull_request_reviews_000001.json":         &reviews,
+		"issue_comments_000001.json":               &issueComments,
+		"pull_request_review_comments_000001.json": &reviewComments,
+		"users_000001.json":                        &users,
+	} {
+		if err := json.Unmarshal(files[name], records); err != nil {
+			t.Fatalf("invalid %s: %s", name, err)
+		}
+	}
+
+	if len(repos) != 1 || repos[0]["git_url"] != "tarball://root/repositories/"+config.ghOrg+"/my-repo.git" || repos[0]["default_branch"] != "main" {
+		t.Errorf("unexpected repositories: %v", repos)
+	}
+	if len(prs) != 2 || jsonString(prs[0], "head", "ref") != "feature" || len(jsonString(prs[0], "head", "sha")) != 40 {
+		t.Fatalf("expected PRs 1 and 3 with a resolved head, got %v", prs)
+	}
+	if mergedAt := githubTime(runGit(t, work, "show", "-s", "--format=%cI", mergeCommit)); prs[1]["merged_at"] != mergedAt {
+		t.Errorf("expected PR 3 to be merged at %v, the time of its merge commit, got %v", mergedAt, prs[1]["merged_at"])
+	}
+	// the review comment on the inline comment comes after the reviewer's request for changes
+	if len(reviews) != 2 || reviews[0]["state"] != float64(githubReviewChangesRequested) {
+		t.Errorf("expected the requested changes as a review, got %v", reviews)
+	}
+	if len(issueComments) != 1 || issueComments[0]["body"] != "looks good" {
+		t.Errorf("unexpected issue comments: %v", issueComments)
+	}
+	if len(reviewComments) != 1 || reviewComments[0]["path"] != "feature.txt" || reviewComments[0]["position"] != float64(1) {
+		t.Errorf("unexpected review comments: %v", reviewComments)
+	}
+	if len(users) != 3 {
+		t.Errorf("expected the author, commenter and reviewer as users, got %v", users)
+	}
+	if reviews[0]["user"] != githubWebURL(config)+"/jane-doe" {
+		t.Errorf("expected the mapped reviewer to keep their github login, got %v", reviews[0]["user"])
+	}
+}
+
+func TestFindDiffPosition(t *testing.T) {
+	diff := `diff --git a/file.txt b/file.txt
+index 1111111..2222222 100644
+--- a/file.txt
++++ b/file.txt
+@@ -1,3 +1,3 @@
+ one
+-two
++TWO
+ three
+@@ -10,2 +10,3 @@ func
+ ten
++ten and a half
+ eleven
+`
+	tests := []struct {
+		line     int
+		oldSide  bool
+		position int
+		found    bool
+	}{
+		{1, false, 1, true},
+		{2, true, 2, true},
+		{2, false, 3, true},
+		{11, false, 7, true},
+		{5, false, 0, false},
+	}
+	for _, test := range tests {
+		position, _, found := findDiffPosition(diff, test.line, test.oldSide)
+		if position != test.position || found != test.found {
+			t.Errorf("line %d old %v: got position %d found %v, want %d %v", test.line, test.oldSide, position, found, test.position, test.found)
+		}
+	}
+}
+
+func TestMigrationArchiveRecordsWithoutID(t *testing.T) {
+	converter := newMigrationConverter(t.TempDir(), "my-repo", settings{ghOrg: "my-org"})
+	err := converter.addPullRequest(exportedPullRequest{PullRequest: json.RawMessage(`{"title": "no id"}`)})
+	if err == nil {
+		t.Error("no error for a pull request without an id")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// the urls of the Github instance we migrate to
+type githubEndpoints struct {
+	api    string
+	upload string
+	web    string
+}
+
+// works out the api, upload and web urls from GITHUB_BASE_URL and GITHUB_UPLOAD_URL.
+// GITHUB_BASE_URL can be either the web url or the api url of
+// Github Enterprise Server (https://ghes.example.com or https://ghes.example.com/api/v3)
+// or GHE.com (https://example.ghe.com or https://api.example.ghe.com)
+func resolveGithubEndpoints(config settings) (githubEndpoints, error) {
+	endpoints := githubEndpoints{
+		api:    "https://api.github.com/",
+		upload: "https://uploads.github.com/",
+		web:    "https://github.com",
+	}
+	if config.ghBaseURL != "" {
+		var err error
+		endpoints, err = enterpriseEndpoints(config.ghBaseURL)
+		if err != nil {
+			return githubEndpoints{}, err
+		}
+	}
+	if config.ghUploadURL != "" {
+		endpoints.upload = config.ghUploadURL
+		if !strings.HasSuffix(endpoints.upload, "/") {
+			endpoints.upload += "/"
+		}
+	}
+	return endpoints, nil
+}
+
+func enterpriseEndpoints(baseURL string) (githubEndpoints, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return githubEndpoints{}, fmt.Errorf("GITHUB_BASE_URL is not a valid url: %w", err)
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return githubEndpoints{}, fmt.Errorf("GITHUB_BASE_URL must look like https://ghes.example.com, got %s", baseURL)
+	}
+
+	if strings.HasSuffix(base.Host, ".ghe.com") {
+		// GHE.com serves the api and uploads from subdomains instead of paths
+		host := strings.TrimPrefix(strings.TrimPrefix(base.Host, "api."), "uploads.")
+		return githubEndpoints{
+			api:    fmt.Sprintf("%s://api.%s/", base.Scheme, host),
+			upload: fmt.Sprintf("%s://uploads.%s/", base.Scheme, host),
+			web:    fmt.Sprintf("%s://%s", base.Scheme, host),
+		}, nil
+	}
+	// Github Enterprise Server serves the api under /api/v3 and uploads under /api/uploads
+	root := strings.TrimSuffix(strings.TrimSuffix(base.Path, "/"), "/api/v3")
+	web := fmt.Sprintf("%s://%s%s", base.Scheme, base.Host, root)
+	return githubEndpoints{
+		api:    web + "/api/v3/",
+		upload: web + "/api/uploads/",
+		web:    web,
+	}, nil
+}
+
+func newGithubClient(httpClient *http.Client, config settings) (*github.Client, error) {
+	endpoints, err := resolveGithubEndpoints(config)
+	if err != nil {
+		return nil, err
+	}
+	client := github.NewClient(httpClient)
+	client.BaseURL, err = url.Parse(endpoints.api)
+	if err != nil {
+		return nil, err
+	}
+	client.UploadURL, err = url.Parse(endpoints.upload)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// returns the web url of the Github instance, eg https://github.com
+func githubWebURL(config settings) string {
+	endpoints, err := resolveGithubEndpoints(config)
+	if err != nil {
+		// settings are validated when the client is created, so this shouldn't happen
+		panic(err)
+	}
+	return endpoints.web
+}
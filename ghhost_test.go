@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestResolveGithubEndpoints(t *testing.T) {
+	tests := []struct {
+		baseURL   string
+		uploadURL string
+		expected  githubEndpoints
+	}{
+		{"", "", githubEndpoints{"https://api.github.com/", "https://uploads.github.com/", "https://github.com"}},
+		{"", "https://uploads.example.com", githubEndpoints{"https://api.github.com/", "https://uploads.example.com/", "https://github.com"}},
+		{"https://ghes.example.com", "", githubEndpoints{"https://ghes.example.com/api/v3/", "https://ghes.example.com/api/uploads/", "https://ghes.example.com"}},
+		{"https://ghes.example.com/api/v3/", "", githubEndpoints{"https://ghes.example.com/api/v3/", "https://ghes.example.com/api/uploads/", "https://ghes.example.com"}},
+		{"https://example.com/github/api/v3", "", githubEndpoints{"https://example.com/github/api/v3/", "https://example.com/github/api/uploads/", "https://example.com/github"}},
+		{"https://example.ghe.com", "", githubEndpoints{"https://api.example.ghe.com/", "https://uploads.example.ghe.com/", "https://example.ghe.com"}},
+		{"https://api.example.ghe.com/", "", githubEndpoints{"https://api.example.ghe.com/", "https://uploads.example.ghe.com/", "https://example.ghe.com"}},
+		{"https://uploads.example.ghe.com", "", githubEndpoints{"https://api.example.ghe.com/", "https://uploads.example.ghe.com/", "https://example.ghe.com"}},
+		{"https://ghes.example.com", "https://uploads.ghes.example.com/", githubEndpoints{"https://ghes.example.com/api/v3/", "https://uploads.ghes.example.com/", "https://ghes.example.com"}},
+	}
+	for _, test := range tests {
+		endpoints, err := resolveGithubEndpoints(settings{ghBaseURL: test.baseURL, ghUploadURL: test.uploadURL})
+		if err != nil {
+			t.Errorf("base %q upload %q: %s", test.baseURL, test.uploadURL, err)
+			continue
+		}
+		if endpoints != test.expected {
+			t.Errorf("base %q upload %q: got %+v, want %+v", test.baseURL, test.uploadURL, endpoints, test.expected)
+		}
+	}
+
+	for _, baseURL := range []string{"ghes.example.com", "https://", "://ghes.example.com"} {
+		if _, err := resolveGithubEndpoints(settings{ghBaseURL: baseURL}); err == nil {
+			t.Errorf("no error for GITHUB_BASE_URL %q", baseURL)
+		}
+	}
+}
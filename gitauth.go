@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// answers git's username and password prompts from env vars, so credentials
+// never end up in a remote url, on the command line, in a file or in git's output
+const askpassScript = `#!/bin/sh
+case "$1" in
+Username*) printf '%s\n' "$BTG_GIT_USERNAME" ;;
+*) printf '%s\n' "$BTG_GIT_PASSWORD" ;;
+esac
+`
+
+type gitCredentials struct {
+	username string
+	password string
+}
+
+// returns the credentials git should use for bitbucket, or nil when cloning via ssh
+func bitbucketGitCredentials(config settings) *gitCredentials {
+	if strings.ToLower(config.cloneVia) == "ssh" {
+		return nil
+	}
+	if config.bbTokenSource == nil {
+		return &gitCredentials{username: config.bbUsername, password: config.bbPassword}
+	}
+	token, err := config.bbTokenSource.Token()
+	if err != nil {
+		log.Fatalf("failed to get bitbucket token for git: %s", err)
+	}
+	return &gitCredentials{username: bitbucketTokenGitUser, password: token.AccessToken}
+}
+
+// returns the credentials git should use for github, the token is fetched
+// right before each git command so Github App tokens are always fresh
+func githubGitCredentials(config settings) *gitCredentials {
+	token, err := config.ghTokenSource.Token()
+	if err != nil {
+		log.Fatalf("failed to get github token for git: %s", err)
+	}
+	return &gitCredentials{username: "x-access-token", password: token.AccessToken}
+}
+
+// returns env vars that make git authenticate with creds through a temporary askpass script,
+// ignoring any credential helper the user has configured.
+// call cleanup once the git command has finished.
+// with nil creds git is only stopped from prompting, eg for ssh which uses the user's keys
+func gitAuthEnv(creds *gitCredentials) (env []string, cleanup func()) {
+	env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if creds == nil {
+		return env, func() {}
+	}
+
+	dir, err := os.MkdirTemp("", "btg-askpass-*")
+	if err != nil {
+		log.Fatalf("Failed to create temp directory: %s", err)
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	script := filepath.Join(dir, "askpass.sh")
+	err = os.WriteFile(script, []byte(askpassScript), 0700)
+	if err != nil {
+		cleanup()
+		log.Fatalf("Failed to write git askpass script: %s", err)
+	}
+
+	env = append(env,
+		"GIT_ASKPASS="+script,
+		"BTG_GIT_USERNAME="+creds.username,
+		"BTG_GIT_PASSWORD="+creds.password,
+		// an empty credential.helper clears the helpers from the user's git config
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=credential.helper",
+		"GIT_CONFIG_VALUE_0=",
+	)
+	return env, cleanup
+}
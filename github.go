@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"log"
+	"net/http"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/go-github/v72/github"
-	"github.com/ktrysmt/go-bitbucket"
 )
 
 // replaces invalid chars in input that are not allowed in Github topics
@@ -18,196 +20,272 @@ func cleanTopic(input string) string {
 	return strings.ReplaceAll(strings.ToLower(input), " ", "-")
 }
 
-func createRepo(gh *github.Client, repo *bitbucket.Repository, config settings) *github.Repository {
-	var visibility string
-	if repo.Is_private {
-		visibility = config.visibility
-	} else {
-		visibility = "public"
-	}
-	ghRepo := &github.Repository{
-		Name:          github.Ptr(repo.Slug),
-		Visibility:    github.Ptr(visibility),
-		Description:   github.Ptr(repo.Description),
-		DefaultBranch: github.Ptr(repo.Mainbranch.Name),
-		Language:      github.Ptr(repo.Language),
-		Organization: &github.Organization{
-			Name: github.Ptr(config.ghOrg),
-		},
-		Topics: []string{"migratedFromBitbucket", cleanTopic(repo.Project.Name)},
+// returns the github repo or nil if it doesn't exist
+func findGithubRepo(gh *github.Client, githubOrg string, name string) *github.Repository {
+	repo, resp, err := gh.Repositories.Get(context.Background(), githubOrg, name)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		log.Fatalf("failed to get github repo %s/%s: %s", githubOrg, name, err)
 	}
+	return repo
+}
 
-	if config.dryRun {
-		return ghRepo
+func plannedGithubRepo(plan repoPlan) *github.Repository {
+	return &github.Repository{
+		Name:          github.Ptr(plan.GithubName),
+		Visibility:    github.Ptr(plan.Repo.Visibility),
+		Description:   github.Ptr(plan.Repo.Description),
+		DefaultBranch: github.Ptr(plan.Repo.DefaultBranch),
+		Language:      github.Ptr(plan.Repo.Language),
+		Organization: &github.Organization{
+			Name: github.Ptr(plan.GithubOrg),
+		},
 	}
+}
 
-	fmt.Printf("Creating repo %s/%s\n", config.ghOrg, repo.Slug)
-	repoCreated := false
-	_, _, err := gh.Repositories.Create(context.Background(), config.ghOrg, ghRepo)
+func createRepo(gh *github.Client, plan repoPlan) {
+	fmt.Printf("Creating repo %s/%s\n", plan.GithubOrg, plan.GithubName)
+	_, _, err := gh.Repositories.Create(context.Background(), plan.GithubOrg, plannedGithubRepo(plan))
 	if err != nil {
 		if strings.Contains(err.Error(), "name already exists on this account") {
-			if !config.overwrite {
-				log.Fatalf("Refusing to overwrite Github repo %s", repo.Slug)
-			}
-		} else {
-			log.Fatalf("failed to create repo %s, error: %s", repo.Slug, err)
+			log.Fatalf("Github repo %s was created since the plan was made, run plan again", plan.GithubName)
 		}
-	}
-
-	if repoCreated {
-		return ghRepo
+		log.Fatalf("failed to create repo %s, error: %s", plan.GithubName, err)
 	}
 
 	// The repository might not have been created yet
 	// Wait for the repository to be available
 	for i := 0; i < 20; i++ {
 		time.Sleep(200 * time.Millisecond)
-		response, _, _ := gh.Repositories.Get(context.Background(), config.ghOrg, repo.Slug)
+		response, _, _ := gh.Repositories.Get(context.Background(), plan.GithubOrg, plan.GithubName)
 		if response != nil {
 			fmt.Println("Repo has been created!")
-			return ghRepo
+			return
 		}
-		fmt.Printf("Waiting for repo %s to be available on GitHub (attempt %d)...", repo.Slug, i+1)
+		fmt.Printf("Waiting for repo %s to be available on GitHub (attempt %d)...", plan.GithubName, i+1)
 		// Wait for a short period before retrying
 		time.Sleep(1 * time.Second)
 	}
 	log.Fatalf("Repo has still not been created")
-	return nil
 }
 
-// you need to call this after createRepo and pushRepoToGithub because
+// you need to call this after createRepo and pushRefsToGithub because
 // topics can't be updated until the repository has contents
-func updateRepoTopics(gh *github.Client, githubOrg string, ghRepo *github.Repository, dryRun bool) {
-	if dryRun {
-		fmt.Println("Mock updating repo topics")
-		return
+func updateRepoTopics(gh *github.Client, plan repoPlan) {
+	fmt.Printf("Updating repo %s/%s topics\n", plan.GithubOrg, plan.GithubName)
+	_, _, err := gh.Repositories.ReplaceAllTopics(context.Background(), plan.GithubOrg, plan.GithubName, plan.Settings.Topics)
+	if err != nil {
+		log.Fatalf("failed to update topics for repo %s, error: %s", plan.GithubName, err)
+	}
+}
+
+func updateCustomProperties(gh *github.Client, plan repoPlan) {
+	var customProps []*github.CustomPropertyValue
+	for name, value := range plan.Settings.CustomProperties {
+		customProps = append(customProps, &github.CustomPropertyValue{PropertyName: name, Value: value})
 	}
-	fmt.Printf("Updating repo %s/%s topics\n", githubOrg, *ghRepo.Name)
-	_, _, err := gh.Repositories.ReplaceAllTopics(context.Background(), githubOrg, *ghRepo.Name, ghRepo.Topics)
+	gh.Repositories.CreateOrUpdateCustomProperties(context.Background(), plan.GithubOrg, plan.GithubName, customProps)
+}
+
+func updateRepo(gh *github.Client, plan repoPlan) {
+	fmt.Printf("Updating repo %s/%s default branch\n", plan.GithubOrg, plan.GithubName)
+	_, _, err := gh.Repositories.Edit(context.Background(), plan.GithubOrg, plan.GithubName, plannedGithubRepo(plan))
 	if err != nil {
-		log.Fatalf("failed to update topics for repo %s, error: %s", *ghRepo.Name, err)
+		log.Fatalf("failed to update repo %s, error: %s", plan.GithubName, err)
 	}
 }
 
-func updateCustomProperties(gh *github.Client, githubOrg string, ghRepo *github.Repository, dryRun bool, projectName string) {
-	customProps := []*github.CustomPropertyValue{
-		{
-			PropertyName: "bitbucket",
-			Value:        "true",
-		},
-		{
-			PropertyName: "project",
-			Value:        cleanTopic(projectName),
-		},
+// migrate an open pull request. returns false if Github refused it
+func createPullRequest(gh *github.Client, plan repoPlan, pr plannedPullRequest) (int, bool) {
+	prID := strconv.Itoa(pr.BitbucketID)
+	gh_pr := &github.NewPullRequest{
+		Title: github.Ptr(pr.Title),
+		Body:  github.Ptr(pr.Body),
+		Head:  github.Ptr(pr.Head),
+		Base:  github.Ptr(pr.Base),
+		Draft: github.Ptr(pr.Draft),
 	}
-	if dryRun {
-		return
+	newPr, _, err := gh.PullRequests.Create(context.Background(), plan.GithubOrg, plan.GithubName, gh_pr)
+	if err != nil {
+		if strings.Contains(err.Error(), "A pull request already exists") {
+			fmt.Printf("Skipping PR creation for PR %s, PR already exists\n", prID)
+		} else if strings.Contains(err.Error(), "422 Validation Failed [{Resource:PullRequest Field:head Code:invalid Message:}]") {
+			fmt.Printf("Could not make PR %s, originating branch %s likely no longer exists\n", prID, pr.Head)
+		} else if pr.State != "" && strings.Contains(err.Error(), "No commits between") {
+			fmt.Printf("Could not make PR %s, there are no commits between its base and head\n", prID)
+		} else {
+			log.Fatalf("failed to create PR %s, error: %s", prID, err)
+		}
+		return 0, false
+	}
+	fmt.Printf("Migrated BB PR %s as GH PR %s\n", prID, strconv.Itoa(*newPr.Number))
+	if pr.State == "" {
+		return *newPr.Number, true
+	}
+
+	commentOnMergeCommit(gh, plan, pr.MergeCommit, *newPr.Number)
+	_, _, err = gh.PullRequests.Edit(context.Background(), plan.GithubOrg, plan.GithubName, *newPr.Number, &github.PullRequest{State: github.Ptr("closed")})
+	if err != nil {
+		log.Fatalf("failed to close PR #%d: %s", *newPr.Number, err)
 	}
-	gh.Repositories.CreateOrUpdateCustomProperties(context.Background(), githubOrg, *ghRepo.Name, customProps)
+	return *newPr.Number, true
 }
 
-func updateRepo(gh *github.Client, githubOrg string, ghRepo *github.Repository, dryRun bool) {
-	if dryRun {
-		fmt.Println("Mock updating repo default branch")
-		return
+// create a closed issue for a merged pull request
+func createClosedIssue(gh *github.Client, plan repoPlan, planned plannedIssue) int {
+	prID := strconv.Itoa(planned.BitbucketID)
+	issue := &github.IssueRequest{
+		Title:  github.Ptr(planned.Title),
+		Body:   github.Ptr(planned.Body),
+		Labels: &planned.Labels,
+		State:  github.Ptr("closed"),
+	}
+	fmt.Printf("Updating issue for PR %s\n", prID)
+	issueResponse, _, err := gh.Issues.Create(context.Background(), plan.GithubOrg, plan.GithubName, issue)
+	if err != nil {
+		log.Fatalf("failed to create issue for PR %s, error: %s", prID, err)
+	}
+
+	commentOnMergeCommit(gh, plan, planned.MergeCommit, *issueResponse.Number)
+
+	// we can't create a closed issue directly so we have to edit the issue to close it
+	_, _, err = gh.Issues.Edit(context.Background(), plan.GithubOrg, plan.GithubName, *issueResponse.Number, issue)
+	if err != nil {
+		log.Fatalf("failed to close issue %s: %s", *issueResponse.URL, err)
 	}
-	fmt.Printf("Updating repo %s/%s default branch\n", githubOrg, *ghRepo.Name)
-	_, _, err := gh.Repositories.Edit(context.Background(), githubOrg, *ghRepo.Name, ghRepo)
+	return *issueResponse.Number
+}
+
+// asks the users to review the PR. Github refuses users without access to the repo and the PR's author,
+// which only skips the review request
+func requestReviewers(gh *github.Client, plan repoPlan, number int, logins []string) {
+	_, _, err := gh.PullRequests.RequestReviewers(context.Background(), plan.GithubOrg, plan.GithubName, number, github.ReviewersRequest{Reviewers: logins})
 	if err != nil {
-		log.Fatalf("failed to update repo %s, error: %s", *ghRepo.Name, err)
+		fmt.Printf("Could not request reviews from %s on PR #%d: %s\n", strings.Join(logins, ", "), number, err)
 	}
 }
 
-// cleans pr summary to nicely display in Github
-func cleanBitbucketPRSummary(prSummary string) string {
-	prSummary = strings.ReplaceAll(prSummary, "{: data-inline-card='' }", "")
-	prSummary = strings.ReplaceAll(prSummary, "\u200c", "") // weird non-printing char, ignore
-	return prSummary
+func addIssueComment(gh *github.Client, plan repoPlan, number int, body string) {
+	_, _, err := gh.Issues.CreateComment(context.Background(), plan.GithubOrg, plan.GithubName, number, &github.IssueComment{Body: github.Ptr(body)})
+	if err != nil {
+		log.Fatalf("failed to comment on #%d: %s", number, err)
+	}
 }
 
-// migrate open pull requests
-func migrateOpenPrs(gh *github.Client, githubOrg string, ghRepo *github.Repository, prs *PullRequests, dryRun bool) {
-	for _, pr := range prs.Values {
-		if pr.State != "OPEN" {
-			continue
-		}
-		prID := strconv.Itoa(pr.ID)
-		prSummary := cleanBitbucketPRSummary(pr.Summary.Raw)
-		text := fmt.Sprintf("PR originally created by %s on %s. Migrated from bitbucket on %s\n\n---\n%s", pr.Author["display_name"].(string), pr.CreatedOn, time.Now().Format(time.RFC3339Nano), prSummary)
-		title := "Historical Bitbucket PR #" + prID + ": " + pr.Title
-		branch := pr.Source["branch"].(map[string]any)["name"].(string)
-		gh_pr := &github.NewPullRequest{
-			Title: &title,
-			Body:  &text,
-			Head:  &branch,
-			Base:  ghRepo.DefaultBranch,
-			Draft: &pr.Draft,
-		}
-		if dryRun {
-			return
-		}
-		newPr, _, err := gh.PullRequests.Create(context.Background(), githubOrg, *ghRepo.Name, gh_pr)
-		if err != nil {
-			if strings.Contains(err.Error(), "A pull request already exists") {
-				fmt.Printf("Skipping PR creation for PR %s, PR already exists\n", prID)
-			} else if strings.Contains(err.Error(), "422 Validation Failed [{Resource:PullRequest Field:head Code:invalid Message:}]") {
-				fmt.Printf("Could not make PR %s, originating branch %s likely no longer exists\n", prID, *gh_pr.Head)
-			} else {
-				log.Fatalf("failed to create PR %s, error: %s", strconv.Itoa(pr.ID), err)
-			}
-			// sleep for .5s to help avoid github rate limit
-			time.Sleep(time.Millisecond * 500)
-			continue
-		}
-		fmt.Printf("Migrated BB PR %s as GH PR %s\n", prID, strconv.Itoa(*newPr.Number))
+// links the merge commit of a bitbucket PR to the Github PR or issue it became
+func commentOnMergeCommit(gh *github.Client, plan repoPlan, mergeCommit string, number int) {
+	if mergeCommit == "" {
+		return
+	}
+	comment := &github.RepositoryComment{
+		Body: github.Ptr("Bitbucket PR details: #" + strconv.Itoa(number)),
+	}
+	_, _, err := gh.Repositories.CreateComment(context.Background(), plan.GithubOrg, plan.GithubName, mergeCommit, comment)
+	if err != nil {
+		log.Fatalf("failed to comment on commit %s: %s", mergeCommit, err)
+	}
+}
 
-		// sleep for .5s to help avoid github rate limit
-		time.Sleep(time.Millisecond * 500)
+// create a closed and locked issue that only takes up the number of a bitbucket PR
+func createPlaceholderIssue(gh *github.Client, plan repoPlan, placeholder plannedPlaceholder) int {
+	issue := &github.IssueRequest{
+		Title:  github.Ptr(placeholder.Title),
+		Body:   github.Ptr(placeholder.Body),
+		Labels: &[]string{"bitbucketPR"},
+		State:  github.Ptr("closed"),
 	}
+	fmt.Printf("Creating placeholder issue for PR %d\n", placeholder.BitbucketID)
+	issueResponse, _, err := gh.Issues.Create(context.Background(), plan.GithubOrg, plan.GithubName, issue)
+	if err != nil {
+		log.Fatalf("failed to create placeholder issue for PR %d, error: %s", placeholder.BitbucketID, err)
+	}
+	_, _, err = gh.Issues.Edit(context.Background(), plan.GithubOrg, plan.GithubName, *issueResponse.Number, issue)
+	if err != nil {
+		log.Fatalf("failed to close issue %s: %s", *issueResponse.URL, err)
+	}
+	_, err = gh.Issues.Lock(context.Background(), plan.GithubOrg, plan.GithubName, *issueResponse.Number, &github.LockIssueOptions{LockReason: "resolved"})
+	if err != nil {
+		log.Fatalf("failed to lock issue %s: %s", *issueResponse.URL, err)
+	}
+	return *issueResponse.Number
 }
 
-// create pull requests
-func createClosedPrs(gh *github.Client, githubOrg string, ghRepo *github.Repository, prs *PullRequests, dryRun bool) {
-	for _, pr := range prs.Values {
-		if pr.State != "MERGED" {
-			continue
-		}
+// works for PRs too, their body is the body of their issue
+func updateIssueBody(gh *github.Client, plan repoPlan, number int, body string) {
+	_, _, err := gh.Issues.Edit(context.Background(), plan.GithubOrg, plan.GithubName, number, &github.IssueRequest{Body: github.Ptr(body)})
+	if err != nil {
+		log.Fatalf("failed to update body of #%d: %s", number, err)
+	}
+}
 
-		prSummary := cleanBitbucketPRSummary(pr.Summary.Raw)
-		text := fmt.Sprintf("**Bitbucket PR created on %s by %s**\n\n---\n%s", pr.CreatedOn, pr.Author["display_name"].(string), prSummary)
-		title := "Historical Bitbucket PR #" + strconv.Itoa(pr.ID) + ": " + pr.Title
-		issue := &github.IssueRequest{
-			Title:  &title,
-			Body:   &text,
-			Labels: &[]string{"bitbucketPR"},
-			State:  github.Ptr("closed"),
-		}
-		if dryRun {
-			return
-		}
-		fmt.Printf("Updating issue for PR %s\n", strconv.Itoa(pr.ID))
-		issueResponse, _, err := gh.Issues.Create(context.Background(), githubOrg, *ghRepo.Name, issue)
+// commits the files to attachmentsBranch through the git data api, on top of what earlier runs copied there,
+// so no clone is needed. returns the url of every file by its path
+func uploadGithubAttachments(gh *github.Client, plan repoPlan, files map[string][]byte, config settings) map[string]string {
+	ctx := context.Background()
+	org, name := plan.GithubOrg, plan.GithubName
+	var parents []*github.Commit
+	var baseTree string
+	ref, resp, err := gh.Git.GetRef(ctx, org, name, "heads/"+attachmentsBranch)
+	if err == nil {
+		parent, _, err := gh.Git.GetCommit(ctx, org, name, ref.GetObject().GetSHA())
 		if err != nil {
-			log.Fatalf("failed to create issue for PR %s, error: %s", strconv.Itoa(pr.ID), err)
+			log.Fatalf("failed to get the commit of branch %s: %s", attachmentsBranch, err)
 		}
+		parents = []*github.Commit{{SHA: parent.SHA}}
+		baseTree = parent.GetTree().GetSHA()
+	} else if resp == nil || resp.StatusCode != http.StatusNotFound {
+		log.Fatalf("failed to get branch %s: %s", attachmentsBranch, err)
+	}
 
-		commitHash := pr.MergeCommit.Hash
-		comment := &github.RepositoryComment{
-			Body: github.Ptr("Bitbucket PR details: #" + strconv.Itoa(*issueResponse.Number)),
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	var entries []*github.TreeEntry
+	for _, path := range paths {
+		blob, resp, err := gh.Git.CreateBlob(ctx, org, name, &github.Blob{
+			Content:  github.Ptr(base64.StdEncoding.EncodeToString(files[path])),
+			Encoding: github.Ptr("base64"),
+		})
+		// the git data api refuses to write to a repo without commits, eg with MIGRATE_REPO_CONTENTS=false
+		if resp != nil && resp.StatusCode == http.StatusConflict {
+			fmt.Printf("Github repo %s/%s has no commits yet, leaving the links to %d attachments as they are\n", org, name, len(files))
+			return map[string]string{}
 		}
-		_, _, err = gh.Repositories.CreateComment(context.Background(), githubOrg, *ghRepo.Name, commitHash, comment)
 		if err != nil {
-			log.Fatalf("failed to comment on commit %s: %s", commitHash, err)
+			log.Fatalf("failed to upload attachment %s: %s", path, err)
 		}
+		entries = append(entries, &github.TreeEntry{Path: github.Ptr(path), Mode: github.Ptr("100644"), Type: github.Ptr("blob"), SHA: blob.SHA})
+	}
+	tree, _, err := gh.Git.CreateTree(ctx, org, name, baseTree, entries)
+	if err != nil {
+		log.Fatalf("failed to create tree of attachments: %s", err)
+	}
+	commit, _, err := gh.Git.CreateCommit(ctx, org, name, &github.Commit{
+		Message: github.Ptr(fmt.Sprintf("Copy %d attachments from Bitbucket PRs", len(files))),
+		Tree:    tree,
+		Parents: parents,
+	}, nil)
+	if err != nil {
+		log.Fatalf("failed to commit attachments: %s", err)
+	}
+	if ref == nil {
+		_, _, err = gh.Git.CreateRef(ctx, org, name, &github.Reference{Ref: github.Ptr("refs/heads/" + attachmentsBranch), Object: &github.GitObject{SHA: commit.SHA}})
+	} else {
+		_, _, err = gh.Git.UpdateRef(ctx, org, name, &github.Reference{Ref: github.Ptr("refs/heads/" + attachmentsBranch), Object: &github.GitObject{SHA: commit.SHA}}, false)
+	}
+	if err != nil {
+		log.Fatalf("failed to update branch %s: %s", attachmentsBranch, err)
+	}
 
-		// we can't create a closed issue directly so we have to edit the issue to close it
-		_, _, err = gh.Issues.Edit(context.Background(), githubOrg, *ghRepo.Name, *issueResponse.Number, issue)
-		if err != nil {
-			log.Fatalf("failed to close issue %s: %s", *issueResponse.URL, err)
-		}
-		// sleep for .5s to help avoid github rate limit
-		time.Sleep(time.Millisecond * 500)
+	urls := map[string]string{}
+	for _, path := range paths {
+		urls[path] = fmt.Sprintf("%s/%s/%s/raw/%s/%s", githubWebURL(config), org, name, attachmentsBranch, path)
 	}
+	return urls
 }
 
 func runProgram(repoFolder string, program string) ([]byte, error) {
@@ -219,36 +297,61 @@ func runProgram(repoFolder string, program string) ([]byte, error) {
 	}
 }
 
-// pushes all repo branches&tags to Github with --mirror option.
-// default branch may get updated as a side-effect
-func pushRepoToGithub(repoFolder string, repoName string, config settings) {
+func githubCloneURL(repoName string, config settings) string {
+	return fmt.Sprintf("%s/%s/%s.git", githubWebURL(config), config.ghOrg, repoName)
+}
+
+// how many refs are pushed per git push, to keep the command line short
+const pushBatchSize = 100
+
+// pushes the planned branch and tag updates to Github, including deletes.
+// runs GITHUB_RUN_PROGRAM on the repo first
+func pushRefsToGithub(repoFolder string, repoName string, push *refPush, config settings) {
 	const newOrigin string = "newOrigin"
 
-	cmd := exec.Command("git", "remote", "add", newOrigin, fmt.Sprintf("https://github.com/%s/%s.git", config.ghOrg, repoName))
+	// the remote stays, the temporary branches of closed PRs are pushed through it later
+	cmd := exec.Command("git", "remote", "get-url", newOrigin)
 	cmd.Dir = repoFolder
-	output, err := cmd.CombinedOutput()
-	fmt.Print(string(output))
-	if err != nil {
-		log.Fatalf("Failed to add new git origin: %s\nOutput: %s", err, string(output))
-	}
-
-	output, err = runProgram(repoFolder, config.runProgram)
-	fmt.Print(string(output))
-	if err != nil {
-		log.Fatalf("Failed to run custom program %s. err: %s", config.runProgram, err)
+	var output []byte
+	var err error
+	if cmd.Run() != nil {
+		cmd = exec.Command("git", "remote", "add", newOrigin, githubCloneURL(repoName, config))
+		cmd.Dir = repoFolder
+		output, err = cmd.CombinedOutput()
+		fmt.Print(string(output))
+		if err != nil {
+			log.Fatalf("Failed to add new git origin: %s\nOutput: %s", err, string(output))
+		}
 	}
 
-	if config.dryRun {
-		return
+	if push.Program != "" {
+		output, err = runProgram(repoFolder, push.Program)
+		fmt.Print(string(output))
+		if err != nil {
+			log.Fatalf("Failed to run custom program %s. err: %s", push.Program, err)
+		}
 	}
 
 	fmt.Println("Pushing repo", repoName, "to github")
 
-	cmd = exec.Command("git", "push", newOrigin, "--mirror")
-	cmd.Dir = repoFolder
-	output, err = cmd.CombinedOutput()
-	if err != nil {
-		log.Fatalf("Failed to push: %s\nOutput: %s", err, string(output))
+	var refspecs []string
+	for _, update := range push.Refs {
+		if update.New == "" {
+			refspecs = append(refspecs, ":"+update.Ref)
+		} else {
+			refspecs = append(refspecs, "+"+update.Ref+":"+update.Ref)
+		}
+	}
+	for batch := range slices.Chunk(refspecs, pushBatchSize) {
+		cmd = exec.Command("git", append([]string{"push", newOrigin}, batch...)...)
+		cmd.Dir = repoFolder
+		env, cleanup := gitAuthEnv(githubGitCredentials(config))
+		cmd.Env = env
+		output, err = cmd.CombinedOutput()
+		cleanup()
+		if err != nil {
+			log.Fatalf("Failed to push: %s\nOutput: %s", err, string(output))
+		}
+		fmt.Print(string(output))
 	}
-	fmt.Print(string(output))
 }
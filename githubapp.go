@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+	"golang.org/x/oauth2"
+)
+
+// installation tokens last an hour, refresh them a bit before they expire
+// so a token never runs out in the middle of a git push
+const installationTokenRefreshWindow = 10 * time.Minute
+
+// mints installation tokens for a Github App
+type githubAppTokenSource struct {
+	appID          string
+	installationID int64
+	privateKey     *rsa.PrivateKey
+	config         settings
+	// permissions granted to the last token
+	permissions *github.InstallationPermissions
+}
+
+func newGithubAppTokenSource(config settings) (oauth2.TokenSource, *githubAppTokenSource, error) {
+	installationID, err := strconv.ParseInt(config.ghAppInstallationID, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GITHUB_APP_INSTALLATION_ID must be a number: %w", err)
+	}
+	privateKey, err := loadPrivateKey(config.ghAppPrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	source := &githubAppTokenSource{
+		appID:          config.ghAppID,
+		installationID: installationID,
+		privateKey:     privateKey,
+		config:         config,
+	}
+	return oauth2.ReuseTokenSourceWithExpiry(nil, source, installationTokenRefreshWindow), source, nil
+}
+
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read GITHUB_APP_PRIVATE_KEY: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("GITHUB_APP_PRIVATE_KEY is not a PEM file")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse GITHUB_APP_PRIVATE_KEY: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("GITHUB_APP_PRIVATE_KEY is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// returns a JWT signed with the app's private key, used to authenticate as the app itself.
+// see https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app
+func (s *githubAppTokenSource) appJWT() (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		// backdated to allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": s.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// creates a new installation token
+func (s *githubAppTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.appJWT()
+	if err != nil {
+		return nil, fmt.Errorf("could not sign github app JWT: %w", err)
+	}
+	appClient, err := newGithubClient(s.config.apiClient, s.config)
+	if err != nil {
+		return nil, err
+	}
+	token, _, err := appClient.WithAuthToken(jwt).Apps.CreateInstallationToken(context.Background(), s.installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create github app installation token: %w", err)
+	}
+	s.permissions = token.GetPermissions()
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		TokenType:   "token",
+		Expiry:      token.GetExpiresAt().Time,
+	}, nil
+}
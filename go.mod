@@ -8,10 +8,14 @@ require (
 	github.com/mitchellh/mapstructure v1.5.0
 )
 
-require github.com/google/go-github/v72 v72.0.0
+require (
+	github.com/google/go-github/v72 v72.0.0
+	golang.org/x/oauth2 v0.29.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/google/go-querystring v1.1.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
 	golang.org/x/net v0.39.0 // indirect
-	golang.org/x/oauth2 v0.29.0 // indirect
 )
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"archive/tar"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/ktrysmt/go-bitbucket"
+)
+
+// migrates the repos in the export archives in EXPORT_DIR to github without talking to bitbucket,
+// so the migration can be split between a host that reaches bitbucket and one that reaches github
+func importRepos(gh *github.Client, config settings) {
+	archives, err := filepath.Glob(filepath.Join(config.exportDir, "*.tar.gz"))
+	if err != nil || len(archives) == 0 {
+		log.Fatalf("no export archives found in %s", config.exportDir)
+	}
+	slices.Sort(archives)
+
+	var sources []*archiveSource
+	for _, archive := range archives {
+		fmt.Println("Extracting", archive)
+		src, err := openArchive(archive)
+		if err != nil {
+			log.Fatalf("invalid export archive %s: %s", archive, err)
+		}
+		defer src.close()
+		sources = append(sources, src)
+	}
+
+	entries := selectArchivedRepos(sources, config.repoFile)
+	refs := loadReferenceMap(config)
+	if config.dryRun {
+		fmt.Println("Dry Run - not actually importing anything")
+	}
+	for _, entry := range entries {
+		src := archivedRepo(sources, entry.slug)
+		repoConfig := repoSettings(entry, config)
+		repoConfig.bbWorkspace = src.manifest.Workspace
+		if repoConfig.revokeOldPerms {
+			fmt.Println("skipping revoking old bitbucket permissions, import can't reach bitbucket")
+			repoConfig.revokeOldPerms = false
+		}
+
+		plan := planRepo(src, newGithubTarget(gh, repoConfig), entry, repoConfig)
+		printPlan(migrationPlan{Repos: []repoPlan{plan}})
+		if !plan.DryRun {
+			applyRepoPlan(src, newGithubTarget(gh, repoConfig), plan, refs)
+		}
+	}
+}
+
+// returns the repos to import: the ones in REPO_FILE if it is set, otherwise every archived repo
+func selectArchivedRepos(sources []*archiveSource, repoFile string) []repoEntry {
+	if repoFile == "" {
+		var entries []repoEntry
+		for _, src := range sources {
+			entries = append(entries, repoEntry{name: src.manifest.Slug, slug: src.manifest.Slug})
+		}
+		return entries
+	}
+
+	var archivedRepos []bitbucket.Repository
+	for _, src := range sources {
+		archivedRepos = append(archivedRepos, *src.repo)
+	}
+	var entries []repoEntry
+	var problems []string
+	for _, entry := range parseRepos(repoFile) {
+		slug, err := resolveRepo(archivedRepos, entry.name)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		entry.slug = slug
+		entries = append(entries, entry)
+	}
+	if len(problems) > 0 {
+		fmt.Println("Could not find some repos in", repoFile, "in the export archives")
+		for _, problem := range problems {
+			fmt.Println(" -", problem)
+		}
+		os.Exit(2)
+	}
+	return entries
+}
+
+func archivedRepo(sources []*archiveSource, slug string) *archiveSource {
+	for _, src := range sources {
+		if src.manifest.Slug == slug {
+			return src
+		}
+	}
+	return nil
+}
+
+// a source that reads an extracted export archive instead of bitbucket
+type archiveSource struct {
+	dir      string
+	manifest exportManifest
+	repo     *bitbucket.Repository
+}
+
+// extracts archive to a temp dir and checks it against its manifest
+func openArchive(archive string) (*archiveSource, error) {
+	dir, err := os.MkdirTemp("", "btg-import-*")
+	if err != nil {
+		return nil, err
+	}
+	src := &archiveSource{dir: dir}
+	err = extractTarball(archive, dir)
+	if err == nil {
+		err = src.load()
+	}
+	if err != nil {
+		src.close()
+		return nil, err
+	}
+	return src, nil
+}
+
+func (s *archiveSource) close() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *archiveSource) load() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, exportManifestFile))
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, &s.manifest)
+	if err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	if s.manifest.FormatVersion != exportFormatVersion {
+		return fmt.Errorf("archive has format version %d, this version of btg reads version %d", s.manifest.FormatVersion, exportFormatVersion)
+	}
+	for _, file := range s.manifest.Files {
+		actual, err := checksumFile(s.dir, file.Path)
+		if err != nil {
+			return err
+		}
+		if actual.SHA256 != file.SHA256 {
+			return fmt.Errorf("checksum of %s does not match the manifest", file.Path)
+		}
+	}
+
+	var repoJSON map[string]any
+	err = readArchiveJSON(s.dir, exportRepoFile, &repoJSON)
+	if err != nil {
+		return err
+	}
+	s.repo, err = decodeRepository(repoJSON)
+	return err
+}
+
+func (s *archiveSource) getRepo(slug string) *bitbucket.Repository {
+	return s.repo
+}
+
+func (s *archiveSource) listRefs(slug string) map[string]string {
+	refs, err := localRefs(filepath.Join(s.dir, exportMirrorDir))
+	if err != nil {
+		log.Fatalf("Failed to list refs of archived repo %s: %s", slug, err)
+	}
+	return refs
+}
+
+func (s *archiveSource) listPermissionChanges(slug string) []permissionChange {
+	return nil
+}
+
+func (s *archiveSource) setPermission(slug string, change permissionChange) {
+	log.Fatalf("can't change bitbucket permissions of %s from an export archive", slug)
+}
+
+// the mirror clone in the archive is used as is
+func (s *archiveSource) cloneRepo(slug string) string {
+	return filepath.Join(s.dir, exportMirrorDir)
+}
+
+// returns the PRs in every state, like getPrs
+func (s *archiveSource) getPrs(slug string) *PullRequests {
+	paths, err := filepath.Glob(filepath.Join(s.dir, exportPrsDir, "*.json"))
+	if err != nil {
+		log.Fatalf("Failed to list archived PRs of %s: %s", slug, err)
+	}
+	prs := &PullRequests{}
+	for _, path := range paths {
+		var exported struct {
+			PullRequest map[string]any `json:"pull_request"`
+		}
+		err = readArchiveJSON(s.dir, filepath.Join(exportPrsDir, filepath.Base(path)), &exported)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		pr, err := decodePullRequest(exported.PullRequest)
+		if err != nil {
+			log.Fatalf("invalid archived PR %s: %s", path, err)
+		}
+		prs.Values = append(prs.Values, *pr)
+	}
+	slices.SortFunc(prs.Values, func(i PullRequest, j PullRequest) int {
+		return cmp.Compare(i.ID, j.ID)
+	})
+	prs.Size = len(prs.Values)
+	return prs
+}
+
+func (s *archiveSource) fetchFork(repoFolder string, fork string, branch string, ref string) error {
+	return fmt.Errorf("forks can't be fetched from an export archive")
+}
+
+func (s *archiveSource) getPrReviews(slug string, id int) prReviews {
+	exported := s.readPr(id)
+	reviews, err := parsePrReviews(exported.PullRequest, exported.Activity)
+	if err != nil {
+		log.Fatalf("Failed to read reviews of archived PR %d of %s: %s", id, slug, err)
+	}
+	return reviews
+}
+
+// archives from before tasks were exported have none
+func (s *archiveSource) getPrTasks(slug string, id int) []prTask {
+	exported := s.readPr(id)
+	tasks, err := parsePrTasks(exported.Tasks, exported.Comments)
+	if err != nil {
+		log.Fatalf("Failed to read tasks of archived PR %d of %s: %s", id, slug, err)
+	}
+	return tasks
+}
+
+func (s *archiveSource) downloadAttachment(link string) ([]byte, error) {
+	return nil, fmt.Errorf("attachments are not in the archive")
+}
+
+func (s *archiveSource) readPr(id int) exportedPullRequest {
+	var exported exportedPullRequest
+	err := readArchiveJSON(s.dir, filepath.Join(exportPrsDir, fmt.Sprintf("%d.json", id)), &exported)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	return exported
+}
+
+func readArchiveJSON(dir string, path string, value any) error {
+	data, err := os.ReadFile(filepath.Join(dir, path))
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return nil
+}
+
+// extracts a gzipped tarball to dir, refusing paths that would end up outside dir
+func extractTarball(archive string, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("%s is outside the archive", header.Name)
+		}
+		if header.Typeflag == tar.TypeDir {
+			err = os.MkdirAll(path, 0755)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0777)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportExportedRepo(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	mergeCommit := runGit(t, work, "rev-parse", "main")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	bb.addPr("my-repo", 2, "MERGED", "merged-branch", mergeCommit)
+	bb.addPr("my-repo", 3, "DECLINED", "feature", "")
+
+	config := testSettings(t, bb, gh)
+	config.exportDir = t.TempDir()
+	if _, err := newBitbucketAPI(&config); err != nil {
+		t.Fatal(err)
+	}
+	exportRepos([]repoEntry{{name: "my-repo", slug: "my-repo"}}, config)
+
+	// import must not need bitbucket at all
+	bbRefs, err := listRemoteRefs(bitbucketCloneURL("my-repo", config), bitbucketGitCredentials(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb.server.Close()
+	repoFile := filepath.Join(t.TempDir(), "repos.txt")
+	os.WriteFile(repoFile, []byte("My Repo,imported-repo\n"), 0644)
+	config.repoFile = repoFile
+	config.bbWorkspace = ""
+	ghClient, err := newGithubAPI(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	importRepos(ghClient, config)
+
+	repo := gh.repo("imported-repo")
+	if repo == nil {
+		t.Fatal("github repo imported-repo was not created")
+	}
+	if repo.repo.GetDefaultBranch() != "main" || len(repo.topics) != 2 {
+		t.Errorf("repo settings were not imported: %+v %v", repo.repo, repo.topics)
+	}
+	if len(repo.issues) != 3 || !repo.issues[0].IsPR || repo.issues[1].IsPR || !repo.issues[2].IsPR || repo.issues[2].State != "closed" {
+		t.Errorf("expected PR 1 as a PR, PR 2 as an issue and PR 3 as a closed PR, got %d issues", len(repo.issues))
+	}
+	ghRefs := mustListRemoteRefs(githubCloneURL("imported-repo", config), githubGitCredentials(config))
+	if updates := diffRefs(ghRefs, bbRefs); len(updates) > 0 {
+		t.Errorf("github refs differ from bitbucket: %+v", updates)
+	}
+}
@@ -1,22 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
-	"time"
 
 	"github.com/google/go-github/v72/github"
-	"github.com/joho/godotenv"
 	"github.com/ktrysmt/go-bitbucket"
+	"golang.org/x/oauth2"
 )
 
 type settings struct {
 	bbWorkspace         string
 	bbUsername          string
 	bbPassword          string
+	bbAccessToken       string
+	bbOAuthClientID     string
+	bbOAuthClientSecret string
+	bbURL               string
+	bbAPIURL            string
 	revokeOldPerms      bool
 	cloneVia            string
 	ghOrg               string
@@ -30,83 +38,264 @@ type settings struct {
 	migrateRepoSettings bool
 	migrateOpenPrs      bool
 	migrateClosedPrs    bool
+	discoverRepos       bool
+	projectKeys         string
+	nameRegex           string
+	updatedSince        string
+	excludeArchived     bool
+	archiveProjects     string
+	repoFileOutput      string
+	nameTemplate        string
+	planFile            string
+	cassetteMode        string
+	cassetteDir         string
+	exportDir           string
+	exportFormat        string
+	refMapFile          string
+	alignPrNumbers      bool
+	migrateAttachments  bool
+	userMapFile         string
+	ghBaseURL           string
+	ghUploadURL         string
+	ghAppID             string
+	ghAppPrivateKey     string
+	ghAppInstallationID string
+	// provides the token for the api and git push, either GITHUB_TOKEN or a Github App installation token
+	ghTokenSource oauth2.TokenSource
+	// provides the bitbucket access token or OAuth token for git clone, nil for username/app password auth
+	bbTokenSource oauth2.TokenSource
+	// sends every api request, records or replays them when HTTP_CASSETTE_MODE is set
+	apiClient *http.Client
 }
 
+// a repo to migrate. target is the explicit Github name from the repo file, if any.
+// overrides are per repo settings from a manifest, keyed by env var name
+type repoEntry struct {
+	name      string
+	slug      string
+	target    string
+	overrides map[string]string
+}
+
+const usage = `usage: btg <command> [flags]
+
+commands:
+  migrate    migrate repos from bitbucket to github (the default)
+  plan       show what migrate would do without changing anything and save it to PLAN_FILE
+  apply      make exactly the changes in PLAN_FILE
+  preflight  check credentials, repos and environment before migrating
+  verify     check that migrated github repos match bitbucket
+  sync       push new bitbucket commits to already migrated github repos
+  report     print a summary of the repos to migrate
+  export     write an archive of each bitbucket repo and its metadata to EXPORT_DIR
+  import     migrate the repos archived in EXPORT_DIR to github without bitbucket access
+
+Every setting can be passed as a flag, an env var or in the config file (.env by default).
+Flags take precedence over env vars, which take precedence over the config file.
+Run btg <command> -h to see all flags.
+`
+
+var commands = []string{"migrate", "plan", "apply", "preflight", "verify", "sync", "report", "export", "import"}
+
 func main() {
-	err := godotenv.Load(".env")
+	command := "migrate"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	if !slices.Contains(commands, command) {
+		fmt.Print(usage)
+		if command == "help" {
+			return
+		}
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("btg "+command, flag.ExitOnError)
+	loadSettings := registerSettingFlags(flags)
+	flags.Parse(args)
+	config, err := loadSettings()
 	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
-
-	config := settings{
-		bbWorkspace:         os.Getenv("BITBUCKET_WORKSPACE"),
-		bbUsername:          os.Getenv("BITBUCKET_USER"),
-		bbPassword:          os.Getenv("BITBUCKET_TOKEN"),
-		revokeOldPerms:      getEnvVarAsBool("BITBUCKET_REVOKEOLDPERMS"),
-		cloneVia:            os.Getenv("CLONE_VIA"),
-		ghOrg:               os.Getenv("GITHUB_ORG"),
-		ghToken:             os.Getenv("GITHUB_TOKEN"),
-		dryRun:              getEnvVarAsBool("GITHUB_DRYRUN"),
-		overwrite:           getEnvVarAsBool("GITHUB_OVERWRITE"),
-		visibility:          getEnvOrDefault("GITHUB_PRIVATE_VISIBILITY", "internal"),
-		runProgram:          getEnvOrDefault("GITHUB_RUN_PROGRAM", "noop"),
-		repoFile:            os.Getenv("REPO_FILE"),
-		migrateRepoContents: getEnvVarAsBool("MIGRATE_REPO_CONTENTS"),
-		migrateRepoSettings: getEnvVarAsBool("MIGRATE_REPO_SETTINGS"),
-		migrateOpenPrs:      getEnvVarAsBool("MIGRATE_OPEN_PRS"),
-		migrateClosedPrs:    getEnvVarAsBool("MIGRATE_CLOSED_PRS"),
-	}
-
-	if config.bbWorkspace == "" || config.bbUsername == "" || config.bbPassword == "" {
-		fmt.Println("BITBUCKET_WORKSPACE or BITBUCKET_USER or BITBUCKET_TOKEN not set in .env file or env vars")
+		fmt.Println(err)
 		os.Exit(2)
 	}
 
-	if config.ghOrg == "" || config.ghToken == "" {
-		fmt.Println("GITHUB_ORG or GITHUB_TOKEN not set in .env file or env vars")
+	var githubClient *github.Client
+	var bitbucketClient *bitbucket.Client
+	switch command {
+	case "export":
+		bitbucketClient, err = newBitbucketAPI(&config)
+	case "import":
+		githubClient, err = newGithubAPI(&config)
+	default:
+		githubClient, bitbucketClient, err = newClients(&config)
+	}
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(2)
 	}
 
-	repos := parseRepos(config.repoFile)
+	if command == "apply" {
+		applyPlan(githubClient, bitbucketClient, readPlan(config.planFile), config)
+		return
+	}
+
+	validateNameTemplate(config.nameTemplate)
 
-	bitbucketClient := bitbucket.NewBasicAuth(config.bbUsername, config.bbPassword)
-	githubClient := github.NewClient(nil).WithAuthToken(config.ghToken)
+	if command == "import" {
+		importRepos(githubClient, config)
+		return
+	}
 
-	migrateRepos(githubClient, bitbucketClient, repos, config)
+	// preflight resolves the repos itself, so that repos and credentials that are wrong show up as failed checks
+	if command == "preflight" {
+		if !runPreflight(githubClient, bitbucketClient, config) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var repos []repoEntry
+	if config.discoverRepos {
+		repos = discoverRepos(bitbucketClient, config)
+	} else {
+		var problems []string
+		repos, problems = resolveRepos(bitbucketClient, config.bbWorkspace, parseRepos(config.repoFile))
+		if len(problems) > 0 {
+			fmt.Println("Could not resolve some repos in", config.repoFile)
+			for _, problem := range problems {
+				fmt.Println(" -", problem)
+			}
+			os.Exit(2)
+		}
+	}
+	if config.repoFileOutput != "" {
+		writeRepoFile(config.repoFileOutput, repos, config)
+	}
+
+	switch command {
+	case "migrate":
+		migrateRepos(githubClient, bitbucketClient, repos, config)
+	case "plan":
+		plan := planMigration(githubClient, bitbucketClient, repos, config)
+		printPlan(plan)
+		writePlan(config.planFile, plan)
+	case "verify":
+		if !verifyRepos(githubClient, bitbucketClient, repos, config) {
+			os.Exit(1)
+		}
+	case "sync":
+		syncRepos(githubClient, bitbucketClient, repos, config)
+	case "report":
+		reportRepos(githubClient, bitbucketClient, repos, config)
+	case "export":
+		exportRepos(repos, config)
+	}
 }
 
-// returns defaultVal if envVar is not present or empty
-func getEnvOrDefault(envVar string, defaultVal string) string {
-	result := os.Getenv(envVar)
-	if result == "" {
-		return defaultVal
+// checks the credentials in config and creates the github and bitbucket clients.
+// also sets the token sources in config that git uses
+func newClients(config *settings) (*github.Client, *bitbucket.Client, error) {
+	bitbucketClient, err := newBitbucketAPI(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	githubClient, err := newGithubAPI(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return githubClient, bitbucketClient, nil
+}
+
+// same as newClients but only for bitbucket, for commands that don't need github
+func newBitbucketAPI(config *settings) (*bitbucket.Client, error) {
+	if config.bbWorkspace == "" {
+		return nil, errors.New("BITBUCKET_WORKSPACE not set in flags, env vars or config file")
+	}
+	err := setAPIClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	bitbucketClient, bbTokenSource, err := newBitbucketClient(*config)
+	if err != nil {
+		return nil, err
+	}
+	config.bbTokenSource = bbTokenSource
+	return bitbucketClient, nil
+}
+
+// same as newClients but only for github, for commands that don't need bitbucket
+func newGithubAPI(config *settings) (*github.Client, error) {
+	err := setAPIClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	usesGithubApp := config.ghAppID != "" || config.ghAppPrivateKey != "" || config.ghAppInstallationID != ""
+	if config.ghOrg == "" || (config.ghToken == "" && !usesGithubApp) {
+		return nil, errors.New("GITHUB_ORG or GITHUB_TOKEN not set in flags, env vars or config file")
+	}
+
+	if usesGithubApp {
+		if config.ghAppID == "" || config.ghAppPrivateKey == "" || config.ghAppInstallationID == "" {
+			return nil, errors.New("GITHUB_APP_ID, GITHUB_APP_PRIVATE_KEY and GITHUB_APP_INSTALLATION_ID must all be set to use a Github App")
+		}
+		config.ghTokenSource, _, err = newGithubAppTokenSource(*config)
+		if err != nil {
+			return nil, err
+		}
 	} else {
-		return result
+		config.ghTokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: config.ghToken})
 	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, config.apiClient)
+	return newGithubClient(oauth2.NewClient(ctx, config.ghTokenSource), *config)
 }
 
-func getEnvVarAsBool(envVar string) bool {
-	result, err := strconv.ParseBool(os.Getenv(envVar))
+// creates the http client for api requests once, shared by both clients
+func setAPIClient(config *settings) error {
+	if config.apiClient != nil {
+		return nil
+	}
+	apiClient, err := newAPIHTTPClient(*config)
 	if err != nil {
-		fmt.Println("could not parse bool env var ", envVar)
-		os.Exit(2)
+		return err
 	}
-	return result
+	config.apiClient = apiClient
+	return nil
 }
 
-func parseRepos(repoFile string) []string {
+// returns config with the repo's manifest overrides applied
+func repoSettings(repo repoEntry, config settings) settings {
+	repoConfig, err := config.withOverrides(repo.overrides)
+	if err != nil {
+		log.Fatalf("invalid settings for repo %s: %s", repo.slug, err)
+	}
+	return repoConfig
+}
+
+func parseRepos(repoFile string) []repoEntry {
 	var repos []string
 	if repoFile == "" {
-		fmt.Println("You must supply a list of names of repos to migrate in REPO_FILE")
+		fmt.Println("You must supply a list of names of repos to migrate in REPO_FILE or set REPO_DISCOVER=true")
 		os.Exit(2)
 	}
+	if isManifest(repoFile) {
+		manifestRepos, err := parseManifest(strings.TrimSpace(repoFile))
+		if err != nil {
+			fmt.Printf("Invalid manifest %s:\n%s\n", repoFile, err)
+			os.Exit(2)
+		}
+		return manifestRepos
+	}
 	data, err := os.ReadFile(strings.TrimSpace(repoFile))
 	if err != nil {
 		log.Fatalf("could not read file %s", repoFile)
 	}
 	repos = strings.Split(string(data), "\n")
 
-	cleaned_repos := []string{}
+	cleaned_repos := []repoEntry{}
 	for _, repo := range repos {
 		repo = strings.TrimSpace(repo)
 		if repo != "" {
@@ -114,77 +303,21 @@ func parseRepos(repoFile string) []string {
 			if repo[0] == "#"[0] {
 				continue
 			}
-			// bitbucket replaces invalid chars with -
-			// see https://support.atlassian.com/bitbucket-cloud/kb/what-is-a-repository-slug/
-			repo = strings.ReplaceAll(repo, " ", "-")
-			repo = strings.ReplaceAll(repo, "/", "-")
-			repo = strings.ReplaceAll(repo, "+", "-")
-			repo = strings.ReplaceAll(repo, "&", "-")
-			repo = strings.ReplaceAll(repo, "(", "-")
-			repo = strings.ReplaceAll(repo, ")", "-")
-			cleaned_repos = append(cleaned_repos, repo)
+			name, target, _ := strings.Cut(repo, ",")
+			cleaned_repos = append(cleaned_repos, repoEntry{
+				name:   strings.TrimSpace(name),
+				target: strings.TrimSpace(target),
+			})
 		}
 	}
 	return cleaned_repos
 }
 
-func migrateRepos(gh *github.Client, bb *bitbucket.Client, repoList []string, config settings) {
+// plans the migration and applies it, unless GITHUB_DRYRUN is true
+func migrateRepos(gh *github.Client, bb *bitbucket.Client, repoList []repoEntry, config settings) {
+	plan := planMigration(gh, bb, repoList, config)
 	if config.dryRun {
-		fmt.Println("Dry Run - not actually migrating anything")
-	}
-
-	for _, repo := range repoList {
-		migrateRepo(gh, bb, repo, config)
-	}
-}
-
-func migrateRepo(gh *github.Client, bb *bitbucket.Client, repoName string, config settings) {
-	fmt.Println("Getting bitbucket settings for", repoName)
-	bbRepo := getRepo(bb, config.bbWorkspace, repoName)
-
-	if config.revokeOldPerms {
-		fmt.Println("revoking old bitbucket permissions to prevent accidental writes")
-		updatePermissionsToReadOnly(bb, config.bbWorkspace, repoName, config.dryRun)
-	} else {
-		fmt.Println("skipping revoking old bitbucket permissions")
-	}
-
-	var repoFolder string
-	if config.migrateRepoContents {
-		repoFolder = cloneRepo(repoName, config)
+		printPlan(plan)
 	}
-	var prs *PullRequests
-	if config.migrateOpenPrs || config.migrateClosedPrs {
-		prs = getPrs(bb, config.bbWorkspace, repoName, bbRepo.Mainbranch.Name)
-	}
-
-	fmt.Println("Migrating to Github")
-	ghRepo := createRepo(gh, bbRepo, config)
-	if config.migrateRepoContents {
-		pushRepoToGithub(repoFolder, repoName, config)
-	} else {
-		fmt.Println("Skipping repo contents")
-	}
-	if config.migrateRepoSettings {
-		updateRepo(gh, config.ghOrg, ghRepo, config.dryRun)
-		updateRepoTopics(gh, config.ghOrg, ghRepo, config.dryRun)
-		updateCustomProperties(gh, config.ghOrg, ghRepo, config.dryRun, bbRepo.Project.Name)
-	} else {
-		fmt.Println("Skipping repo settings")
-	}
-	if config.migrateOpenPrs {
-		migrateOpenPrs(gh, config.ghOrg, ghRepo, prs, config.dryRun)
-	} else {
-		fmt.Println("Skipping open PR's")
-	}
-	if config.migrateClosedPrs {
-		createClosedPrs(gh, config.ghOrg, ghRepo, prs, config.dryRun)
-	} else {
-		fmt.Println("Skipping closed PR's")
-	}
-	fmt.Println("done migrating repo")
-	fmt.Print("-----------------------\n\n")
-
-	// sleep for .5s to help avoid github rate limit
-	time.Sleep(time.Millisecond * 500)
+	applyPlan(gh, bb, plan, config)
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifest columns that describe the repo rather than override a setting
+const (
+	manifestRepoKey   = "repo"
+	manifestTargetKey = "github_name"
+)
+
+// returns a copy of config with the repo's overrides applied
+func (config settings) withOverrides(overrides map[string]string) (settings, error) {
+	var errs []error
+	for key, value := range overrides {
+		field, ok := findSettingField(key)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown setting %s", key))
+			continue
+		}
+		// these apply to the whole run, overriding them per repo would be silently ignored
+		if !field.perRepo {
+			errs = append(errs, fmt.Errorf("%s can only be set globally", key))
+			continue
+		}
+		err := field.set(&config, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	if config.nameTemplate != "" {
+		if err := checkNameTemplate(config.nameTemplate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return config, errors.Join(errs...)
+}
+
+func isManifest(repoFile string) bool {
+	switch strings.ToLower(filepath.Ext(repoFile)) {
+	case ".csv", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// parses a CSV or YAML manifest. every row needs a repo column,
+// the github_name column and any overridable env var column are optional
+func parseManifest(repoFile string) ([]repoEntry, error) {
+	data, err := os.ReadFile(repoFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]string
+	if strings.ToLower(filepath.Ext(repoFile)) == ".csv" {
+		rows, err = parseCsvManifest(data)
+	} else {
+		rows, err = parseYamlManifest(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []repoEntry
+	var errs []error
+	for i, row := range rows {
+		repo := repoEntry{
+			name:      strings.TrimSpace(row[manifestRepoKey]),
+			target:    strings.TrimSpace(row[manifestTargetKey]),
+			overrides: map[string]string{},
+		}
+		if repo.name == "" {
+			errs = append(errs, fmt.Errorf("row %d: missing %s", i+1, manifestRepoKey))
+			continue
+		}
+		for key, value := range row {
+			value = strings.TrimSpace(value)
+			if key == manifestRepoKey || key == manifestTargetKey || value == "" {
+				continue
+			}
+			repo.overrides[strings.ToUpper(key)] = value
+		}
+		// apply the overrides to an empty config so mistakes are caught before migrating anything
+		if _, err := (settings{}).withOverrides(repo.overrides); err != nil {
+			errs = append(errs, fmt.Errorf("repo %s: %w", repo.name, err))
+			continue
+		}
+		repos = append(repos, repo)
+	}
+	return repos, errors.Join(errs...)
+}
+
+func parseCsvManifest(data []byte) ([]map[string]string, error) {
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := map[string]string{}
+		for i, value := range record {
+			row[header[i]] = value
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseYamlManifest(data []byte) ([]map[string]string, error) {
+	var manifest []map[string]any
+	err := yaml.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]string
+	for _, entry := range manifest {
+		row := map[string]string{}
+		for key, value := range entry {
+			if value == nil {
+				continue
+			}
+			row[strings.ToLower(key)] = fmt.Sprint(value)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
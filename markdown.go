@@ -0,0 +1,199 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// converts the markdown bitbucket stores for PR descriptions, comments and tasks to github flavored markdown
+type markdownConverter struct {
+	// mentions of mapped users link to their github profile
+	users    userMap
+	ghWebURL string
+	// display names by account id of the users taking part in the PRs, mentions of their account id show them
+	names map[string]string
+}
+
+func newMarkdownConverter(config settings) *markdownConverter {
+	return &markdownConverter{users: loadUserMap(config.userMapFile), ghWebURL: githubWebURL(config)}
+}
+
+// remembers the display names of users, so mentions of their account id can say who was meant
+func (m *markdownConverter) addUsers(users ...map[string]any) {
+	if m.names == nil {
+		m.names = map[string]string{}
+	}
+	for _, user := range users {
+		if id, name := jsonString(user, "account_id"), jsonString(user, "display_name"); id != "" && name != "" {
+			m.names[strings.ToLower(id)] = name
+		}
+	}
+}
+
+var (
+	// characters bitbucket's editor leaves behind that only get in the way
+	invisibleChars = strings.NewReplacer("\u200b", "", "\u200c", "", "\ufeff", "", "\r\n", "\n")
+
+	codeFence = regexp.MustCompile("^( {0,3})(```+|~~~+)\\s*(.*)$")
+	// python-markdown's language hints on fences, ```{.python} or ``` { .python }
+	fenceAttributes = regexp.MustCompile(`^\{:?\s*\.([\w+#-]+)[^}]*\}$`)
+	// the first line of an indented code block can name its language, :::python or #!python
+	indentedCodeHint = regexp.MustCompile(`^(?: {4}|\t)(?::::|#!)([\w+#-]+)\s*$`)
+	tableDelimiter   = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)+\|?\s*$`)
+
+	// kramdown attribute lists, eg {: data-inline-card='' } after smart links, with the space they leave at the end of a line
+	attributeList = regexp.MustCompile(`\s?\{:[^}\n]*\}(?:\s+$)?`)
+	// links bitbucket shows as cards, the link text is the url itself or the title names the card
+	smartLink       = regexp.MustCompile(`\[([^\]\n]*)\]\((https?://[^\s)]+)(?:\s+"smart(?:Card|Link)-[\w-]+")?\)`)
+	accountMentions = regexp.MustCompile(`@\{([^}\s]+)\}`)
+	// mentions by nickname from bitbucket's old editor. emails and paths aren't mentions
+	nicknameMentions = regexp.MustCompile(`(^|[^\w@/.\[` + "`" + `])@([A-Za-z0-9](?:[\w-]*[A-Za-z0-9])?)\b`)
+	emojiShortcodes  = regexp.MustCompile(`:([a-z0-9_+-]+):`)
+)
+
+// atlassian emoji names that github knows by another name, the rest are the same
+var atlassianEmoji = map[string]string{
+	"slight_smile":     "slightly_smiling_face",
+	"slight_frown":     "slightly_frowning_face",
+	"upside_down":      "upside_down_face",
+	"check_mark":       "heavy_check_mark",
+	"cross_mark":       "x",
+	"info":             "information_source",
+	"light_bulb":       "bulb",
+	"yellow_star":      "star",
+	"question_mark":    "question",
+	"exclamation_mark": "exclamation",
+	"hugging":          "hugs",
+	"nerd":             "nerd_face",
+}
+
+// converts text line by line. code blocks and code spans are left as they are
+func (m *markdownConverter) convert(text string) string {
+	lines := strings.Split(invisibleChars.Replace(text), "\n")
+	var out []string
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if fence := codeFence.FindStringSubmatch(line); fence != nil {
+			info := fence[3]
+			if match := fenceAttributes.FindStringSubmatch(info); match != nil {
+				info = match[1]
+			}
+			out = append(out, fence[1]+fence[2]+info)
+			// everything up to the closing fence is code
+			for i++; i < len(lines); i++ {
+				out = append(out, lines[i])
+				if closing := strings.TrimSpace(lines[i]); strings.HasPrefix(closing, fence[2]) && strings.Trim(closing, fence[2][:1]) == "" {
+					break
+				}
+			}
+			continue
+		}
+
+		if hint := indentedCodeHint.FindStringSubmatch(line); hint != nil && (i == 0 || strings.TrimSpace(lines[i-1]) == "") {
+			block, end := indentedCodeBlock(lines, i+1)
+			out = append(out, "```"+hint[1])
+			out = append(out, block...)
+			out = append(out, "```")
+			i = end - 1
+			continue
+		}
+
+		// github only sees a table when it doesn't continue a paragraph
+		if tableDelimiter.MatchString(line) && len(out) >= 2 && strings.Contains(out[len(out)-1], "|") && strings.TrimSpace(out[len(out)-2]) != "" {
+			header := out[len(out)-1]
+			out = append(out[:len(out)-1], "", header)
+			out = append(out, line)
+			continue
+		}
+
+		out = append(out, m.convertInline(line))
+	}
+	return strings.Join(out, "\n")
+}
+
+// returns the lines of the indented code block starting at start without their indent,
+// and the index of the first line after it
+func indentedCodeBlock(lines []string, start int) ([]string, int) {
+	var block []string
+	end := start
+	for i := start; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			block = append(block, "")
+			continue
+		}
+		if !strings.HasPrefix(line, "    ") && !strings.HasPrefix(line, "\t") {
+			break
+		}
+		block = append(block, strings.TrimPrefix(strings.TrimPrefix(line, "\t"), "    "))
+		end = i + 1
+	}
+	// trailing blank lines are not part of the block
+	return block[:end-start], end
+}
+
+// converts the parts of line that aren't code spans
+func (m *markdownConverter) convertInline(line string) string {
+	var out strings.Builder
+	for line != "" {
+		start := strings.Index(line, "`")
+		if start < 0 {
+			out.WriteString(m.convertText(line))
+			break
+		}
+		out.WriteString(m.convertText(line[:start]))
+		ticks := len(line[start:]) - len(strings.TrimLeft(line[start:], "`"))
+		closing := strings.Index(line[start+ticks:], line[start:start+ticks])
+		if closing < 0 {
+			// an unmatched backtick is just a backtick
+			out.WriteString(line[start : start+ticks])
+			line = line[start+ticks:]
+			continue
+		}
+		end := start + ticks + closing + ticks
+		out.WriteString(line[start:end])
+		line = line[end:]
+	}
+	return out.String()
+}
+
+func (m *markdownConverter) convertText(text string) string {
+	text = attributeList.ReplaceAllString(text, "")
+	text = smartLink.ReplaceAllStringFunc(text, func(link string) string {
+		match := smartLink.FindStringSubmatch(link)
+		if match[1] == match[2] || strings.Contains(link, `"smart`) {
+			// github links bare urls itself
+			return match[2]
+		}
+		return link
+	})
+	text = accountMentions.ReplaceAllStringFunc(text, func(mention string) string {
+		id := accountMentions.FindStringSubmatch(mention)[1]
+		user := map[string]any{"account_id": id}
+		if name := m.names[strings.ToLower(id)]; name != "" && m.users.login(user) == "" {
+			// a name can't notify anyone, so it needs no escaping
+			return name
+		}
+		return m.mention(user, "{"+id+"}")
+	})
+	text = nicknameMentions.ReplaceAllStringFunc(text, func(mention string) string {
+		match := nicknameMentions.FindStringSubmatch(mention)
+		return match[1] + m.mention(map[string]any{"nickname": match[2]}, match[2])
+	})
+	return emojiShortcodes.ReplaceAllStringFunc(text, func(shortcode string) string {
+		if name, ok := atlassianEmoji[strings.Trim(shortcode, ":")]; ok {
+			return ":" + name + ":"
+		}
+		return shortcode
+	})
+}
+
+// mapped users link to their profile instead of being mentioned, migrating old PRs shouldn't notify everyone in them.
+// other mentions are escaped, whoever has that login in github isn't who was meant
+func (m *markdownConverter) mention(user map[string]any, name string) string {
+	if login := m.users.login(user); login != "" {
+		return "[@" + login + "](" + m.ghWebURL + "/" + login + ")"
+	}
+	return "@<!-- -->" + name
+}
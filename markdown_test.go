@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// every testdata/markdown/<name>.json is a PR or comment as the bitbucket api returns it,
+// <name>.md is what its markdown converts to
+func TestConvertMarkdown(t *testing.T) {
+	markdown := &markdownConverter{
+		users:    userMap{"557058:0a1b2c3d": "octocat", "jdoe": "jane-doe"},
+		ghWebURL: "https://github.com",
+	}
+	payloads, err := filepath.Glob(filepath.Join("testdata", "markdown", "*.json"))
+	if err != nil || len(payloads) == 0 {
+		t.Fatalf("no payloads in testdata/markdown: %v", err)
+	}
+	for _, payload := range payloads {
+		name := strings.TrimSuffix(filepath.Base(payload), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			// like migrations, mentions of the people in the payload show their names
+			for _, key := range []string{"author", "user"} {
+				user, _ := fields[key].(map[string]any)
+				markdown.addUsers(user)
+			}
+			raw := jsonString(fields, "summary", "raw")
+			if raw == "" {
+				raw = jsonString(fields, "content", "raw")
+			}
+			actual := markdown.convert(raw)
+
+			golden := strings.TrimSuffix(payload, ".json") + ".md"
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(actual), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s, run go test -run TestConvertMarkdown -update to create it", err)
+			}
+			if actual != string(expected) {
+				t.Errorf("%s converts to:\n%s\nexpected:\n%s", payload, actual, expected)
+			}
+		})
+	}
+}
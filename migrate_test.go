@@ -0,0 +1,309 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// returns settings with every migration step enabled, pointing at the fakes
+func testSettings(t *testing.T, bb *fakeBitbucket, gh *fakeGithub) settings {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	loadSettings := registerSettingFlags(flags)
+	err := flags.Parse([]string{
+		"--config", filepath.Join(t.TempDir(), "missing.env"),
+		"--bitbucket-revokeoldperms=false",
+		"--github-dryrun=false",
+		"--github-overwrite=false",
+		"--migrate-repo-contents=true",
+		"--migrate-repo-settings=true",
+		"--migrate-open-prs=true",
+		"--migrate-closed-prs=true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb.configure(&config)
+	gh.configure(&config)
+	config.refMapFile = filepath.Join(t.TempDir(), "refmap.json")
+	return config
+}
+
+func TestMigrateRepo(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	mergeCommit := runGit(t, work, "rev-parse", "main")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	bb.addPr("my-repo", 2, "MERGED", "merged-branch", mergeCommit)
+
+	config := testSettings(t, bb, gh)
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos, problems := resolveRepos(bbClient, config.bbWorkspace, []repoEntry{{name: "My Repo", target: "renamed-repo"}})
+	if len(problems) > 0 {
+		t.Fatalf("could not resolve repos: %v", problems)
+	}
+
+	migrateRepos(ghClient, bbClient, repos, config)
+
+	repo := gh.repo("renamed-repo")
+	if repo == nil {
+		t.Fatal("github repo renamed-repo was not created")
+	}
+	if repo.repo.GetDefaultBranch() != "main" {
+		t.Errorf("default branch is %q, expected main", repo.repo.GetDefaultBranch())
+	}
+	if repo.repo.GetVisibility() != config.visibility {
+		t.Errorf("visibility is %q, expected %q", repo.repo.GetVisibility(), config.visibility)
+	}
+	if !slices.Equal(repo.topics, []string{"migratedFromBitbucket", "project-proj"}) {
+		t.Errorf("unexpected topics %v", repo.topics)
+	}
+	if len(repo.properties) != 2 {
+		t.Errorf("expected 2 custom properties, got %d", len(repo.properties))
+	}
+
+	if len(repo.issues) != 2 {
+		t.Fatalf("expected a PR and an issue, got %d", len(repo.issues))
+	}
+	pr := repo.issues[0]
+	if !pr.IsPR || pr.Head != "feature" || pr.Base != "main" || pr.State != "open" {
+		t.Errorf("open PR was not migrated correctly: %+v", pr)
+	}
+	if !strings.Contains(pr.Title, "Bitbucket PR #1") || !strings.Contains(pr.Body, "summary of PR 1") {
+		t.Errorf("open PR has unexpected title or body: %+v", pr)
+	}
+	issue := repo.issues[1]
+	if issue.IsPR || issue.State != "closed" || !slices.Equal(issue.Labels, []string{"bitbucketPR"}) {
+		t.Errorf("merged PR was not migrated as a closed issue: %+v", issue)
+	}
+	if comments := repo.commitComments[mergeCommit]; len(comments) != 1 || comments[0] != "Bitbucket PR details: #2" {
+		t.Errorf("unexpected comments on merge commit: %v", comments)
+	}
+
+	if !verifyRepos(ghClient, bbClient, repos, config) {
+		t.Error("verify found differences after migrating")
+	}
+}
+
+func TestMigrateClosedPrs(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	mergeCommit := runGit(t, work, "rev-parse", "main")
+	bb.addPr("my-repo", 1, "MERGED", "feature", mergeCommit)
+	bb.addPr("my-repo", 2, "SUPERSEDED", "feature", "")
+
+	config := testSettings(t, bb, gh)
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := []repoEntry{{name: "my-repo", slug: "my-repo"}}
+	migrateRepos(ghClient, bbClient, repos, config)
+
+	repo := gh.repo("my-repo")
+	if len(repo.issues) != 2 {
+		t.Fatalf("expected 2 PRs, got %d", len(repo.issues))
+	}
+	for _, pr := range repo.issues {
+		if !pr.IsPR || pr.State != "closed" {
+			t.Errorf("expected a closed PR: %+v", pr)
+		}
+	}
+	if pr := repo.issues[0]; pr.Head != "bitbucket-pr/1/head" || pr.Base != "bitbucket-pr/1/base" || !strings.Contains(pr.Body, "Bitbucket PR merged") {
+		t.Errorf("merged PR was not migrated correctly: %+v", pr)
+	}
+	if comments := repo.commitComments[mergeCommit]; len(comments) != 1 || comments[0] != "Bitbucket PR details: #1" {
+		t.Errorf("unexpected comments on merge commit: %v", comments)
+	}
+	// the temporary branches are gone again
+	if !verifyRepos(ghClient, bbClient, repos, config) {
+		t.Error("verify found differences after migrating")
+	}
+}
+
+func TestMigrateClosedPrWithoutCommits(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	bb.addRepo("my-repo", "My Repo", "PROJ")
+	// declined before anything was committed, so head and base are the same commit
+	bb.addPr("my-repo", 1, "DECLINED", "main", "")
+
+	config := testSettings(t, bb, gh)
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrateRepos(ghClient, bbClient, []repoEntry{{name: "my-repo", slug: "my-repo"}}, config)
+
+	repo := gh.repo("my-repo")
+	if len(repo.issues) != 1 {
+		t.Fatalf("expected 1 issue, got %d", len(repo.issues))
+	}
+	if issue := repo.issues[0]; issue.IsPR || issue.State != "closed" || !slices.Contains(issue.Labels, "bitbucketPR") || !strings.Contains(issue.Body, "Bitbucket PR declined") {
+		t.Errorf("expected a closed bitbucketPR issue: %+v", issue)
+	}
+	refs := loadReferenceMap(config)
+	if migrated := refs.Repos[fakeWorkspace+"/my-repo"].PullRequests[1]; !migrated.Issue {
+		t.Errorf("PR 1 should be recorded as an issue: %+v", migrated)
+	}
+}
+
+func TestMigratePrsIntoOtherBranches(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	runGit(t, work, "push", "origin", "main:develop")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	bb.addPr("my-repo", 2, "OPEN", "feature", "")
+	bb.prs["my-repo"][0]["destination"] = map[string]any{"branch": map[string]any{"name": "develop"}}
+	bb.prs["my-repo"][1]["destination"] = map[string]any{"branch": map[string]any{"name": "release/1.0"}}
+
+	config := testSettings(t, bb, gh)
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := planMigration(ghClient, bbClient, []repoEntry{{name: "my-repo", slug: "my-repo"}}, config)
+	if skipped := plan.Repos[0].Skipped; len(skipped) != 1 || !strings.Contains(skipped[0], "destination branch release/1.0 does not exist") {
+		t.Errorf("expected PR 2 to be reported, got %v", skipped)
+	}
+	applyPlan(ghClient, bbClient, plan, config)
+
+	issues := gh.repo("my-repo").issues
+	if len(issues) != 1 || issues[0].Base != "develop" {
+		t.Errorf("expected one PR into develop, got %+v", issues)
+	}
+}
+
+func TestMigrateForkPr(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	// the fork has a branch the repo doesn't
+	fork := filepath.Join(bb.gitRoot, "forker", "my-repo.git")
+	os.MkdirAll(filepath.Dir(fork), 0755)
+	createBareRepo(t, fork)
+	runGit(t, work, "checkout", "-b", "fork-feature")
+	os.WriteFile(filepath.Join(work, "fork.txt"), []byte("fork\n"), 0644)
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-m", "add fork feature")
+	runGit(t, work, "push", fork, "fork-feature")
+	bb.addPr("my-repo", 1, "OPEN", "fork-feature", "")
+	bb.prs["my-repo"][0]["source"] = map[string]any{
+		"branch":     map[string]any{"name": "fork-feature"},
+		"repository": map[string]any{"full_name": "forker/my-repo"},
+	}
+
+	config := testSettings(t, bb, gh)
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := []repoEntry{{name: "my-repo", slug: "my-repo"}}
+	migrateRepos(ghClient, bbClient, repos, config)
+
+	issues := gh.repo("my-repo").issues
+	if len(issues) != 1 || !issues[0].IsPR || issues[0].Head != "bb-fork/forker/fork-feature" {
+		t.Fatalf("expected a PR from the fork's branch, got %+v", issues)
+	}
+	// syncing must not delete the fork branch of the open PR
+	ghRefs := mustListRemoteRefs(githubCloneURL("my-repo", config), githubGitCredentials(config))
+	bbRefs := mustListRemoteRefs(bitbucketCloneURL("my-repo", config), bitbucketGitCredentials(config))
+	if _, ok := ghRefs["refs/heads/bb-fork/forker/fork-feature"]; !ok {
+		t.Error("fork branch was not pushed to github")
+	}
+	if updates := diffRefs(ghRefs, bbRefs); len(updates) > 0 {
+		t.Errorf("sync would change %+v", updates)
+	}
+}
+
+func TestMigrateRepoDryRun(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	bb.addRepo("my-repo", "My Repo", "PROJ")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+
+	config := testSettings(t, bb, gh)
+	config.dryRun = true
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrateRepos(ghClient, bbClient, []repoEntry{{name: "my-repo", slug: "my-repo"}}, config)
+
+	if gh.repo("my-repo") != nil {
+		t.Error("dry run created a github repo")
+	}
+}
+
+func TestVerifyReportsMissingRefs(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+
+	config := testSettings(t, bb, gh)
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := []repoEntry{{name: "my-repo", slug: "my-repo"}}
+	migrateRepos(ghClient, bbClient, repos, config)
+
+	// a branch pushed to bitbucket after the migration
+	runGit(t, work, "checkout", "-b", "late-branch")
+	runGit(t, work, "push", "origin", "late-branch")
+
+	ghRepo := getRepo(bbClient, config.bbWorkspace, "my-repo")
+	problems := verifyRepo(ghClient, ghRepo, "my-repo", config)
+	if len(problems) != 1 || !strings.Contains(problems[0], "refs/heads/late-branch") {
+		t.Errorf("expected late-branch to be missing, got %v", problems)
+	}
+
+	syncRepos(ghClient, bbClient, repos, config)
+	if problems := verifyRepo(ghClient, ghRepo, "my-repo", config); len(problems) > 0 {
+		t.Errorf("sync did not push late-branch: %v", problems)
+	}
+}
+
+func TestResolveRepos(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	bb.addRepo("api", "API", "PROJ")
+	bb.addRepo("web-app", "Web App", "PROJ")
+
+	config := testSettings(t, bb, gh)
+	_, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, problems := resolveRepos(bbClient, config.bbWorkspace, []repoEntry{
+		{name: "api"},
+		{name: "web app"},
+		{name: "missing"},
+	})
+	var slugs []string
+	for _, repo := range resolved {
+		slugs = append(slugs, repo.slug)
+	}
+	if !slices.Equal(slugs, []string{"api", "web-app"}) {
+		t.Errorf("resolved %v, expected api and web-app", slugs)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "missing") {
+		t.Errorf("expected missing to be reported, got %v", problems)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/ktrysmt/go-bitbucket"
+)
+
+var namePlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// chars Github doesn't allow in repo names, Github replaces these with -
+var invalidGithubNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// values GITHUB_NAME_TEMPLATE placeholders are replaced with
+func namePlaceholderValues(repo *bitbucket.Repository, config settings) map[string]string {
+	return map[string]string{
+		"slug":         repo.Slug,
+		"name":         repo.Name,
+		"project_key":  repo.Project.Key,
+		"project_name": repo.Project.Name,
+		"workspace":    config.bbWorkspace,
+	}
+}
+
+// exits if the template uses a placeholder we don't know about
+func validateNameTemplate(template string) {
+	if err := checkNameTemplate(template); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func checkNameTemplate(template string) error {
+	values := namePlaceholderValues(&bitbucket.Repository{}, settings{})
+	for _, match := range namePlaceholder.FindAllStringSubmatch(template, -1) {
+		if _, ok := values[match[1]]; !ok {
+			return fmt.Errorf("GITHUB_NAME_TEMPLATE has unknown placeholder %s", match[0])
+		}
+	}
+	return nil
+}
+
+// returns the name the repo should have in Github.
+// an explicit name from the repo file wins over GITHUB_NAME_TEMPLATE
+func githubRepoName(entry repoEntry, repo *bitbucket.Repository, config settings) string {
+	if entry.target != "" {
+		return cleanGithubRepoName(entry.target)
+	}
+	values := namePlaceholderValues(repo, config)
+	name := namePlaceholder.ReplaceAllStringFunc(config.nameTemplate, func(placeholder string) string {
+		return values[strings.Trim(placeholder, "{}")]
+	})
+	return cleanGithubRepoName(name)
+}
+
+func cleanGithubRepoName(name string) string {
+	return invalidGithubNameChars.ReplaceAllString(name, "-")
+}
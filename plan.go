@@ -0,0 +1,714 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/ktrysmt/go-bitbucket"
+)
+
+// a migration plan lists every change a migration will make.
+// plan writes it to PLAN_FILE so it can be reviewed, and apply makes exactly those changes
+type migrationPlan struct {
+	CreatedAt time.Time  `json:"created_at"`
+	Repos     []repoPlan `json:"repos"`
+}
+
+// the changes migrating one repo makes
+type repoPlan struct {
+	Slug       string            `json:"slug"`
+	Workspace  string            `json:"workspace"`
+	Overrides  map[string]string `json:"overrides,omitempty"`
+	GithubOrg  string            `json:"github_org"`
+	GithubName string            `json:"github_name"`
+	// planned with GITHUB_DRYRUN=true, apply skips the repo
+	DryRun      bool               `json:"dry_run"`
+	Repo        githubRepoFields   `json:"repo"`
+	CreateRepo  bool               `json:"create_repo"`
+	Permissions []permissionChange `json:"permissions,omitempty"`
+	// nil when github already has every branch and tag
+	Push *refPush `json:"push,omitempty"`
+	// nil when MIGRATE_REPO_SETTINGS is false
+	Settings     *repoSettingsUpdate  `json:"settings,omitempty"`
+	PullRequests []plannedPullRequest `json:"pull_requests,omitempty"`
+	Issues       []plannedIssue       `json:"issues,omitempty"`
+	// ALIGN_PR_NUMBERS, the gaps between PRs are filled with placeholders and every number is checked
+	AlignNumbers bool                 `json:"align_numbers,omitempty"`
+	Placeholders []plannedPlaceholder `json:"placeholders,omitempty"`
+	// MIGRATE_ATTACHMENTS, files hosted by bitbucket that the bodies link to
+	Attachments []plannedAttachment `json:"attachments,omitempty"`
+	// things the migration leaves out, with the reason
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+type githubRepoFields struct {
+	Visibility    string `json:"visibility"`
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+	Language      string `json:"language"`
+}
+
+// a bitbucket user or group permission that gets changed
+type permissionChange struct {
+	// user or group
+	Kind string `json:"kind"`
+	// the account id of a user or the slug of a group
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type refPush struct {
+	// GITHUB_RUN_PROGRAM, run on the clone before pushing. empty for noop
+	Program string      `json:"program,omitempty"`
+	Refs    []refUpdate `json:"refs"`
+}
+
+// a branch or tag to push. Old is empty for new refs and New is empty for refs to delete
+type refUpdate struct {
+	Ref string `json:"ref"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+type repoSettingsUpdate struct {
+	Topics           []string          `json:"topics"`
+	CustomProperties map[string]string `json:"custom_properties"`
+}
+
+// a bitbucket PR that becomes a github PR
+type plannedPullRequest struct {
+	BitbucketID int    `json:"bitbucket_id"`
+	Title       string `json:"title"`
+	Body        string `json:"body"`
+	Head        string `json:"head"`
+	Base        string `json:"base"`
+	Draft       bool   `json:"draft"`
+	// merged, declined or superseded for PRs that are closed right after creating them, empty for open PRs
+	State string `json:"state,omitempty"`
+	// closed PRs are opened on temporary Head and Base branches at these commits, which are deleted afterwards
+	HeadCommit string `json:"head_commit,omitempty"`
+	BaseCommit string `json:"base_commit,omitempty"`
+	// gets a comment linking to the PR
+	MergeCommit string `json:"merge_commit,omitempty"`
+	// github logins to request reviews from, only for open PRs
+	Reviewers []string `json:"reviewers,omitempty"`
+	// comment listing who approved or requested changes in bitbucket
+	ApprovalLog string `json:"approval_log,omitempty"`
+	// the workspace/slug of the fork the PR is from. its ForkBranch is fetched and pushed as Head, which is kept
+	Fork       string `json:"fork,omitempty"`
+	ForkBranch string `json:"fork_branch,omitempty"`
+}
+
+// the branches of PRs from forks are pushed under this prefix. they only exist in github
+const forkBranchPrefix = "bb-fork/"
+
+// a closed bitbucket PR that becomes a closed github issue, because its commits are gone
+type plannedIssue struct {
+	BitbucketID int      `json:"bitbucket_id"`
+	Title       string   `json:"title"`
+	Body        string   `json:"body"`
+	Labels      []string `json:"labels"`
+	// gets a comment linking to the issue, empty for PRs that weren't merged
+	MergeCommit string `json:"merge_commit"`
+	ApprovalLog string `json:"approval_log,omitempty"`
+}
+
+// a closed and locked issue that takes up the number of a bitbucket PR that isn't migrated
+type plannedPlaceholder struct {
+	BitbucketID int    `json:"bitbucket_id"`
+	Title       string `json:"title"`
+	Body        string `json:"body"`
+}
+
+// reads bitbucket and github and works out what migrating repos would change
+func planMigration(gh *github.Client, bb *bitbucket.Client, repos []repoEntry, config settings) migrationPlan {
+	plan := migrationPlan{CreatedAt: time.Now()}
+	for _, repo := range repos {
+		repoConfig := repoSettings(repo, config)
+		plan.Repos = append(plan.Repos, planRepo(newBitbucketSource(bb, repoConfig), newGithubTarget(gh, repoConfig), repo, repoConfig))
+	}
+	return plan
+}
+
+func planRepo(src source, dst target, repo repoEntry, config settings) repoPlan {
+	fmt.Println("Planning", repo.slug)
+	bbRepo := src.getRepo(repo.slug)
+	plan := repoPlan{
+		Slug:       repo.slug,
+		Workspace:  config.bbWorkspace,
+		Overrides:  repo.overrides,
+		GithubOrg:  config.ghOrg,
+		GithubName: githubRepoName(repo, bbRepo, config),
+		DryRun:     config.dryRun,
+		Repo: githubRepoFields{
+			Visibility:    "public",
+			Description:   bbRepo.Description,
+			DefaultBranch: bbRepo.Mainbranch.Name,
+			Language:      bbRepo.Language,
+		},
+	}
+	if bbRepo.Is_private {
+		plan.Repo.Visibility = config.visibility
+	}
+
+	ghRefs := map[string]string{}
+	if dst.findRepo(plan.GithubName) == nil {
+		plan.CreateRepo = true
+	} else if !config.overwrite {
+		log.Fatalf("Refusing to overwrite Github repo %s/%s", plan.GithubOrg, plan.GithubName)
+	} else {
+		ghRefs = dst.listRefs(plan.GithubName)
+	}
+
+	if config.revokeOldPerms {
+		plan.Permissions = src.listPermissionChanges(repo.slug)
+	}
+
+	// the branches github will have once the plan is applied
+	refsAfter := ghRefs
+	if config.migrateRepoContents {
+		bbRefs := src.listRefs(repo.slug)
+		if updates := diffRefs(ghRefs, bbRefs); len(updates) > 0 {
+			plan.Push = &refPush{Refs: updates}
+			if config.runProgram != "noop" {
+				plan.Push.Program = config.runProgram
+			}
+		}
+		refsAfter = bbRefs
+	}
+
+	if config.migrateRepoSettings {
+		plan.Settings = &repoSettingsUpdate{
+			Topics: []string{"migratedFromBitbucket", cleanTopic(bbRepo.Project.Name)},
+			CustomProperties: map[string]string{
+				"bitbucket": "true",
+				"project":   cleanTopic(bbRepo.Project.Name),
+			},
+		}
+	}
+
+	if config.migrateOpenPrs || config.migrateClosedPrs {
+		prs := src.getPrs(repo.slug)
+		markdown := newMarkdownConverter(config)
+		for _, pr := range prs.Values {
+			markdown.addUsers(prUsers(pr)...)
+		}
+		// eg a new repo with MIGRATE_REPO_CONTENTS=false, open PRs have no branches to be opened on
+		noBranches := !config.migrateRepoContents && len(refsAfter) == 0
+		skippedOpenPrs := 0
+		for _, pr := range prs.Values {
+			if pr.State == "OPEN" && config.migrateOpenPrs {
+				if noBranches {
+					skippedOpenPrs++
+					continue
+				}
+				planOpenPr(&plan, pr, refsAfter, markdown)
+			}
+			if pr.State != "OPEN" && config.migrateClosedPrs {
+				planClosedPr(&plan, pr, markdown)
+			}
+		}
+		if skippedOpenPrs > 0 {
+			fmt.Printf("Warning: skipping %d open PRs of %s, Github repo %s has no branches and MIGRATE_REPO_CONTENTS is false\n", skippedOpenPrs, repo.slug, plan.GithubName)
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("%d open PRs: the Github repo has no branches to open them on and MIGRATE_REPO_CONTENTS is false", skippedOpenPrs))
+		}
+		planReviews(&plan, src, loadUserMap(config.userMapFile))
+		planTasks(&plan, src, prs.Values, markdown)
+		if config.migrateAttachments {
+			planAttachments(&plan, config)
+		}
+		if config.alignPrNumbers {
+			if plan.CreateRepo {
+				plan.AlignNumbers = true
+				planPlaceholders(&plan, prs.Values)
+			} else {
+				plan.Skipped = append(plan.Skipped, "aligning PR numbers: the Github repo already exists, so its numbers are taken")
+			}
+		}
+	}
+	return plan
+}
+
+// the author, reviewers and participants of the PR, the people mentions in PRs are usually of
+func prUsers(pr PullRequest) []map[string]any {
+	users := append([]map[string]any{pr.Author}, pr.Reviewers...)
+	for _, participant := range pr.Participants {
+		if user, ok := participant["user"].(map[string]any); ok {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+func planOpenPr(plan *repoPlan, pr PullRequest, refsAfter map[string]string, markdown *markdownConverter) {
+	branch := pr.Source["branch"].(map[string]any)["name"].(string)
+	planned := plannedPullRequest{
+		BitbucketID: pr.ID,
+		Title:       "Historical Bitbucket PR #" + strconv.Itoa(pr.ID) + ": " + pr.Title,
+		Head:        branch,
+		Base:        jsonString(pr.Destination, "branch", "name"),
+		Draft:       pr.Draft,
+	}
+	if fork := jsonString(pr.Source, "repository", "full_name"); fork != "" && !strings.EqualFold(fork, plan.Workspace+"/"+plan.Slug) {
+		owner, _, _ := strings.Cut(fork, "/")
+		planned.Head = forkBranchPrefix + owner + "/" + branch
+		planned.Fork = fork
+		planned.ForkBranch = branch
+	} else if _, ok := refsAfter["refs/heads/"+branch]; !ok {
+		plan.Skipped = append(plan.Skipped, fmt.Sprintf("PR #%d: originating branch %s does not exist", pr.ID, branch))
+		return
+	}
+	if _, ok := refsAfter["refs/heads/"+planned.Base]; !ok {
+		plan.Skipped = append(plan.Skipped, fmt.Sprintf("PR #%d: destination branch %s does not exist", pr.ID, planned.Base))
+		return
+	}
+	prSummary := markdown.convert(pr.Summary.Raw)
+	planned.Body = fmt.Sprintf("PR originally created by %s on %s. Migrated from bitbucket on %s\n\n---\n%s", pr.Author["display_name"].(string), pr.CreatedOn, time.Now().Format(time.RFC3339Nano), prSummary)
+	plan.PullRequests = append(plan.PullRequests, planned)
+}
+
+// plans a merged, declined or superseded PR as a closed PR between its original commits,
+// or as a closed issue when bitbucket doesn't know the commits anymore
+func planClosedPr(plan *repoPlan, pr PullRequest, markdown *markdownConverter) {
+	headCommit := jsonString(pr.Source, "commit", "hash")
+	baseCommit := jsonString(pr.Destination, "commit", "hash")
+	if headCommit == "" || baseCommit == "" {
+		plan.Issues = append(plan.Issues, plannedIssue{
+			BitbucketID: pr.ID,
+			Title:       "Historical Bitbucket PR #" + strconv.Itoa(pr.ID) + ": " + pr.Title,
+			Body:        closedPrBody(pr, markdown),
+			Labels:      []string{"bitbucketPR"},
+			MergeCommit: pr.MergeCommit.Hash,
+		})
+		return
+	}
+	plan.PullRequests = append(plan.PullRequests, plannedPullRequest{
+		BitbucketID: pr.ID,
+		Title:       "Historical Bitbucket PR #" + strconv.Itoa(pr.ID) + ": " + pr.Title,
+		Body:        closedPrBody(pr, markdown),
+		Head:        fmt.Sprintf("bitbucket-pr/%d/head", pr.ID),
+		Base:        fmt.Sprintf("bitbucket-pr/%d/base", pr.ID),
+		State:       strings.ToLower(pr.State),
+		HeadCommit:  headCommit,
+		BaseCommit:  baseCommit,
+		MergeCommit: pr.MergeCommit.Hash,
+	})
+}
+
+func closedPrBody(pr PullRequest, markdown *markdownConverter) string {
+	prSummary := markdown.convert(pr.Summary.Raw)
+	return fmt.Sprintf("**Bitbucket PR %s, created on %s by %s**\n\nFrom %s into %s\n\n---\n%s", strings.ToLower(pr.State), pr.CreatedOn,
+		pr.Author["display_name"].(string), jsonString(pr.Source, "branch", "name"), jsonString(pr.Destination, "branch", "name"), prSummary)
+}
+
+// the closed issue a closed PR becomes when github can't have the PR
+func closedPrIssue(pr plannedPullRequest) plannedIssue {
+	return plannedIssue{
+		BitbucketID: pr.BitbucketID,
+		Title:       pr.Title,
+		Body:        pr.Body,
+		Labels:      []string{"bitbucketPR"},
+		MergeCommit: pr.MergeCommit,
+		ApprovalLog: pr.ApprovalLog,
+	}
+}
+
+// fills the numbers no PR or issue is planned for with placeholders, so every bitbucket PR N becomes Github #N
+func planPlaceholders(plan *repoPlan, prs []PullRequest) {
+	planned := map[int]bool{}
+	for _, pr := range plan.PullRequests {
+		planned[pr.BitbucketID] = true
+	}
+	for _, issue := range plan.Issues {
+		planned[issue.BitbucketID] = true
+	}
+	byID := map[int]PullRequest{}
+	last := 0
+	for _, pr := range prs {
+		byID[pr.ID] = pr
+		last = max(last, pr.ID)
+	}
+	for id := 1; id <= last; id++ {
+		if planned[id] {
+			continue
+		}
+		if pr, ok := byID[id]; ok {
+			plan.Placeholders = append(plan.Placeholders, placeholderFor(id, pr.Title, "was "+strings.ToLower(pr.State)+" and is not migrated"))
+		} else {
+			plan.Placeholders = append(plan.Placeholders, placeholderFor(id, "", "was deleted"))
+		}
+	}
+}
+
+func placeholderFor(id int, title string, reason string) plannedPlaceholder {
+	placeholder := plannedPlaceholder{
+		BitbucketID: id,
+		Title:       "Historical Bitbucket PR #" + strconv.Itoa(id),
+		Body:        fmt.Sprintf("Placeholder that keeps Github numbers in line with Bitbucket PR numbers. Bitbucket PR #%d %s.", id, reason),
+	}
+	if title != "" {
+		placeholder.Title += ": " + title
+	}
+	return placeholder
+}
+
+// returns the updates that make the refs in to match from.
+// branches of PRs from forks and the attachments branch only exist in github, so they are never deleted
+func diffRefs(to map[string]string, from map[string]string) []refUpdate {
+	var updates []refUpdate
+	for ref, hash := range from {
+		if to[ref] != hash {
+			updates = append(updates, refUpdate{Ref: ref, Old: to[ref], New: hash})
+		}
+	}
+	for ref, hash := range to {
+		if _, ok := from[ref]; !ok && !strings.HasPrefix(ref, "refs/heads/"+forkBranchPrefix) && ref != "refs/heads/"+attachmentsBranch {
+			updates = append(updates, refUpdate{Ref: ref, Old: hash})
+		}
+	}
+	slices.SortFunc(updates, func(a, b refUpdate) int { return strings.Compare(a.Ref, b.Ref) })
+	return updates
+}
+
+// makes the changes in plan, skipping repos that were planned as a dry run
+func applyPlan(gh *github.Client, bb *bitbucket.Client, plan migrationPlan, config settings) {
+	refs := loadReferenceMap(config)
+	for _, repoPlan := range plan.Repos {
+		if repoPlan.DryRun {
+			fmt.Println("Dry Run - not migrating", repoPlan.Slug)
+			continue
+		}
+		repoConfig := repoSettings(repoEntry{slug: repoPlan.Slug, overrides: repoPlan.Overrides}, config)
+		repoConfig.ghOrg = repoPlan.GithubOrg
+		applyRepoPlan(newBitbucketSource(bb, repoConfig), newGithubTarget(gh, repoConfig), repoPlan, refs)
+	}
+}
+
+func applyRepoPlan(src source, dst target, plan repoPlan, refs *referenceMap) {
+	fmt.Printf("Migrating %s to Github as %s/%s\n", plan.Slug, plan.GithubOrg, plan.GithubName)
+	for _, change := range plan.Permissions {
+		src.setPermission(plan.Slug, change)
+	}
+
+	var repoFolder string
+	if plan.Push != nil || hasExtraPrBranches(plan) {
+		repoFolder = src.cloneRepo(plan.Slug)
+	}
+	if plan.Push != nil {
+		if err := checkClonedRefs(repoFolder, plan.Push.Refs); err != nil {
+			log.Fatalf("%s since the plan was made, run plan again", err)
+		}
+	}
+	if plan.CreateRepo {
+		dst.createRepo(plan)
+	}
+	if plan.Push != nil {
+		dst.pushRefs(repoFolder, plan)
+	}
+	if plan.Settings != nil {
+		dst.updateRepoSettings(plan)
+	}
+	createPullRequestsAndIssues(src, dst, plan, refs, repoFolder)
+	fmt.Println("done migrating repo")
+	fmt.Print("-----------------------\n\n")
+
+	// sleep for .5s to help avoid github rate limit
+	time.Sleep(time.Millisecond * 500)
+}
+
+// whether PRs need branches that aren't in bitbucket, which are pushed from the clone
+func hasExtraPrBranches(plan repoPlan) bool {
+	for _, pr := range plan.PullRequests {
+		if pr.HeadCommit != "" || pr.Fork != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// creates the PRs, issues and placeholders of the plan in bitbucket id order with their references rewritten.
+// when the numbers are aligned it stops as soon as a number is off
+func createPullRequestsAndIssues(src source, dst target, plan repoPlan, refs *referenceMap, repoFolder string) {
+	repo := refs.addRepo(plan)
+	prs := map[int]plannedPullRequest{}
+	issues := map[int]plannedIssue{}
+	placeholders := map[int]plannedPlaceholder{}
+	var ids []int
+	for _, pr := range plan.PullRequests {
+		prs[pr.BitbucketID] = pr
+		ids = append(ids, pr.BitbucketID)
+	}
+	for _, issue := range plan.Issues {
+		issues[issue.BitbucketID] = issue
+		ids = append(ids, issue.BitbucketID)
+	}
+	for _, placeholder := range plan.Placeholders {
+		placeholders[placeholder.BitbucketID] = placeholder
+		ids = append(ids, placeholder.BitbucketID)
+	}
+	slices.Sort(ids)
+
+	// closed PRs are opened on temporary branches at their original commits
+	var tempBranches []refUpdate
+	for _, id := range ids {
+		pr, ok := prs[id]
+		if !ok || pr.HeadCommit == "" {
+			continue
+		}
+		head, headOk := resolveCommit(repoFolder, pr.HeadCommit)
+		base, baseOk := resolveCommit(repoFolder, pr.BaseCommit)
+		if !headOk || !baseOk {
+			fmt.Printf("Commits of PR %d are not in the repo anymore, migrating it as an issue\n", id)
+			delete(prs, id)
+			issues[id] = closedPrIssue(pr)
+			continue
+		}
+		tempBranches = append(tempBranches,
+			refUpdate{Ref: "refs/heads/" + pr.Head, New: head},
+			refUpdate{Ref: "refs/heads/" + pr.Base, New: base})
+	}
+	if err := updateLocalRefs(repoFolder, tempBranches); err != nil {
+		log.Fatalf("Failed to create temporary branches for closed PRs: %s", err)
+	}
+
+	// PRs from forks are opened on the fork's branch, fetched into the clone
+	var forkBranches []refUpdate
+	for _, id := range ids {
+		pr, ok := prs[id]
+		if !ok || pr.Fork == "" {
+			continue
+		}
+		ref := "refs/heads/" + pr.Head
+		err := src.fetchFork(repoFolder, pr.Fork, pr.ForkBranch, ref)
+		if err != nil {
+			fmt.Printf("Could not fetch branch %s of fork %s for PR %d: %s\n", pr.ForkBranch, pr.Fork, id, err)
+			delete(prs, id)
+			if plan.AlignNumbers {
+				placeholder := placeholderFor(id, "", "is from a fork that could not be fetched")
+				placeholder.Title = pr.Title
+				placeholders[id] = placeholder
+			}
+			continue
+		}
+		hash, _ := resolveCommit(repoFolder, ref)
+		forkBranches = append(forkBranches, refUpdate{Ref: ref, New: hash})
+	}
+
+	if branches := slices.Concat(forkBranches, tempBranches); len(branches) > 0 {
+		pushPlan := plan
+		pushPlan.Push = &refPush{Refs: branches}
+		dst.pushRefs(repoFolder, pushPlan)
+	}
+
+	attachments := copyAttachments(src, dst, plan)
+
+	// the bodies as they were created, references to PRs created later are fixed below
+	createdBodies := map[int]string{}
+	for _, id := range ids {
+		var number int
+		if pr, ok := prs[id]; ok {
+			pr.Body = refs.rewrite(rewriteAttachments(pr.Body, attachments), plan.Workspace, plan.Slug)
+			var created bool
+			number, created = dst.createPullRequest(plan, pr)
+			if created {
+				repo.PullRequests[id] = migratedPullRequest{Number: number}
+				createdBodies[id] = pr.Body
+				if len(pr.Reviewers) > 0 {
+					dst.requestReviews(plan, number, pr.Reviewers)
+				}
+				if pr.ApprovalLog != "" {
+					dst.addComment(plan, number, pr.ApprovalLog)
+				}
+			} else if pr.State != "" {
+				// eg when there are no commits between base and head.
+				// the references in its body are fixed below from the PR's original body
+				issue := closedPrIssue(pr)
+				number = dst.createIssue(plan, issue)
+				repo.PullRequests[id] = migratedPullRequest{Number: number, Issue: true}
+				createdBodies[id] = issue.Body
+				if issue.ApprovalLog != "" {
+					dst.addComment(plan, number, issue.ApprovalLog)
+				}
+			} else if plan.AlignNumbers {
+				placeholder := placeholderFor(id, "", "could not be migrated")
+				placeholder.Title = pr.Title
+				number = dst.createPlaceholder(plan, placeholder)
+				repo.PullRequests[id] = migratedPullRequest{Number: number, Issue: true}
+			}
+		} else if issue, ok := issues[id]; ok {
+			issue.Body = refs.rewrite(rewriteAttachments(issue.Body, attachments), plan.Workspace, plan.Slug)
+			number = dst.createIssue(plan, issue)
+			repo.PullRequests[id] = migratedPullRequest{Number: number, Issue: true}
+			createdBodies[id] = issue.Body
+			if issue.ApprovalLog != "" {
+				dst.addComment(plan, number, issue.ApprovalLog)
+			}
+		} else if placeholder, ok := placeholders[id]; ok {
+			number = dst.createPlaceholder(plan, placeholder)
+			repo.PullRequests[id] = migratedPullRequest{Number: number, Issue: true}
+		} else {
+			continue
+		}
+		if plan.AlignNumbers && number != id {
+			refs.save()
+			log.Fatalf("Bitbucket PR %d became Github #%d, the numbers are no longer aligned", id, number)
+		}
+		// sleep for .5s to help avoid github rate limit
+		time.Sleep(time.Millisecond * 500)
+	}
+	refs.save()
+
+	if len(tempBranches) > 0 {
+		fmt.Println("Deleting temporary branches of closed PRs")
+		var deletes []refUpdate
+		for _, branch := range tempBranches {
+			deletes = append(deletes, refUpdate{Ref: branch.Ref, Old: branch.New})
+		}
+		tempPlan := plan
+		tempPlan.Push = &refPush{Refs: deletes}
+		dst.pushRefs(repoFolder, tempPlan)
+	}
+
+	// rewritten from the original body, rewriting a rewritten body would take the new numbers for bitbucket ids
+	for _, id := range ids {
+		createdBody, ok := createdBodies[id]
+		if !ok {
+			continue
+		}
+		body := prs[id].Body
+		if issue, ok := issues[id]; ok {
+			body = issue.Body
+		}
+		body = refs.rewrite(rewriteAttachments(body, attachments), plan.Workspace, plan.Slug)
+		if body != createdBody {
+			fmt.Printf("Updating references in #%d\n", repo.PullRequests[id].Number)
+			dst.updateBody(plan, repo.PullRequests[id].Number, body)
+			// sleep for .5s to help avoid github rate limit
+			time.Sleep(time.Millisecond * 500)
+		}
+	}
+}
+
+// returns an error if bitbucket changed between listing the refs and cloning,
+// pushing the clone would push something else than what was planned
+func checkClonedRefs(repoFolder string, updates []refUpdate) error {
+	cloned, err := localRefs(repoFolder)
+	if err != nil {
+		return fmt.Errorf("could not list refs of %s: %w", repoFolder, err)
+	}
+	for _, update := range updates {
+		if update.New != "" && cloned[update.Ref] != update.New {
+			return fmt.Errorf("%s changed in bitbucket", update.Ref)
+		}
+	}
+	return nil
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 10 {
+		return hash[:10]
+	}
+	return hash
+}
+
+// prints plan in a format meant for people to review
+func printPlan(plan migrationPlan) {
+	for _, repo := range plan.Repos {
+		fmt.Printf("\n%s -> %s/%s\n", repo.Slug, repo.GithubOrg, repo.GithubName)
+		if repo.DryRun {
+			fmt.Println("  (dry run, apply skips this repo)")
+		}
+		if repo.CreateRepo {
+			fmt.Printf("  + create %s repo with default branch %s\n", repo.Repo.Visibility, repo.Repo.DefaultBranch)
+		}
+		for _, change := range repo.Permissions {
+			fmt.Printf("  ~ bitbucket %s %s permission %s -> %s\n", change.Kind, change.Name, change.From, change.To)
+		}
+		if repo.Push != nil {
+			if repo.Push.Program != "" {
+				fmt.Printf("  ! run %s before pushing, pushed commits may differ from the ones below\n", repo.Push.Program)
+			}
+			for _, update := range repo.Push.Refs {
+				switch {
+				case update.Old == "":
+					fmt.Printf("  + push %s %s\n", update.Ref, shortHash(update.New))
+				case update.New == "":
+					fmt.Printf("  - delete %s %s\n", update.Ref, shortHash(update.Old))
+				default:
+					fmt.Printf("  ~ push %s %s..%s\n", update.Ref, shortHash(update.Old), shortHash(update.New))
+				}
+			}
+		}
+		if repo.Settings != nil {
+			fmt.Printf("  ~ set visibility %s, default branch %s, topics %s\n", repo.Repo.Visibility, repo.Repo.DefaultBranch, strings.Join(repo.Settings.Topics, ", "))
+			var properties []string
+			for name, value := range repo.Settings.CustomProperties {
+				properties = append(properties, name+"="+value)
+			}
+			slices.Sort(properties)
+			fmt.Printf("  ~ set custom properties %s\n", strings.Join(properties, ", "))
+		}
+		for _, pr := range repo.PullRequests {
+			if pr.State != "" {
+				fmt.Printf("  + create closed PR %q (%s) %s -> %s\n", pr.Title, pr.State, shortHash(pr.HeadCommit), shortHash(pr.BaseCommit))
+			} else if pr.Fork != "" {
+				fmt.Printf("  + create PR %q %s -> %s, fetching %s from fork %s\n", pr.Title, pr.Head, pr.Base, pr.ForkBranch, pr.Fork)
+			} else {
+				fmt.Printf("  + create PR %q %s -> %s\n", pr.Title, pr.Head, pr.Base)
+			}
+			if len(pr.Reviewers) > 0 {
+				fmt.Printf("    ~ request reviews from %s\n", strings.Join(pr.Reviewers, ", "))
+			}
+		}
+		for _, issue := range repo.Issues {
+			fmt.Printf("  + create closed issue %q and comment on commit %s\n", issue.Title, shortHash(issue.MergeCommit))
+		}
+		for _, placeholder := range repo.Placeholders {
+			fmt.Printf("  + create closed and locked placeholder issue #%d\n", placeholder.BitbucketID)
+		}
+		if repo.AlignNumbers {
+			fmt.Println("  ~ check that Bitbucket PR N becomes Github #N")
+		}
+		if len(repo.Attachments) > 0 {
+			fmt.Printf("  + copy %d attachments to branch %s\n", len(repo.Attachments), attachmentsBranch)
+		}
+		for _, skipped := range repo.Skipped {
+			fmt.Printf("  ! skip %s\n", skipped)
+		}
+	}
+	fmt.Println()
+}
+
+func writePlan(path string, plan migrationPlan) {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode plan: %s", err)
+	}
+	err = os.WriteFile(path, append(data, '\n'), 0644)
+	if err != nil {
+		log.Fatalf("Failed to write plan to %s: %s", path, err)
+	}
+	fmt.Println("Wrote plan to", path, "- run apply to make these changes")
+}
+
+func readPlan(path string) migrationPlan {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("could not read plan %s: %s", path, err)
+	}
+	var plan migrationPlan
+	err = json.Unmarshal(data, &plan)
+	if err != nil {
+		log.Fatalf("invalid plan %s: %s", path, err)
+	}
+	return plan
+}
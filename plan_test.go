@@ -0,0 +1,157 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPlanThenApply(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	bb.addPr("my-repo", 2, "OPEN", "deleted-branch", "")
+	bb.addPr("my-repo", 3, "MERGED", "merged-branch", runGit(t, work, "rev-parse", "main"))
+
+	config := testSettings(t, bb, gh)
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := []repoEntry{{name: "my-repo", slug: "my-repo"}}
+
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+	writePlan(planFile, planMigration(ghClient, bbClient, repos, config))
+	if gh.repo("my-repo") != nil {
+		t.Fatal("planning created the github repo")
+	}
+
+	plan := readPlan(planFile)
+	if len(plan.Repos) != 1 {
+		t.Fatalf("expected 1 repo in the plan, got %d", len(plan.Repos))
+	}
+	repoPlan := plan.Repos[0]
+	if !repoPlan.CreateRepo || repoPlan.Push == nil {
+		t.Fatalf("expected the repo to be created and pushed: %+v", repoPlan)
+	}
+	var refs []string
+	for _, update := range repoPlan.Push.Refs {
+		refs = append(refs, update.Ref)
+	}
+	if !slices.Equal(refs, []string{"refs/heads/feature", "refs/heads/main", "refs/tags/v1.0"}) {
+		t.Errorf("unexpected refs to push %v", refs)
+	}
+	if len(repoPlan.PullRequests) != 1 || repoPlan.PullRequests[0].BitbucketID != 1 {
+		t.Errorf("expected only PR 1 to be created: %+v", repoPlan.PullRequests)
+	}
+	if len(repoPlan.Skipped) != 1 {
+		t.Errorf("expected PR 2 to be skipped: %v", repoPlan.Skipped)
+	}
+	if len(repoPlan.Issues) != 1 || repoPlan.Issues[0].BitbucketID != 3 {
+		t.Errorf("expected an issue for PR 3: %+v", repoPlan.Issues)
+	}
+
+	applyPlan(ghClient, bbClient, plan, config)
+	repo := gh.repo("my-repo")
+	if repo == nil {
+		t.Fatal("apply did not create the github repo")
+	}
+	if len(repo.issues) != 2 {
+		t.Errorf("expected a PR and an issue, got %d", len(repo.issues))
+	}
+
+	// once applied, planning again with overwrite only pushes what changed in bitbucket
+	runGit(t, work, "checkout", "-b", "late-branch")
+	runGit(t, work, "push", "origin", "late-branch")
+	config.overwrite = true
+	config.migrateOpenPrs = false
+	config.migrateClosedPrs = false
+	repoPlan = planMigration(ghClient, bbClient, repos, config).Repos[0]
+	if repoPlan.CreateRepo {
+		t.Error("planned to create a repo that exists")
+	}
+	if repoPlan.Push == nil || len(repoPlan.Push.Refs) != 1 || repoPlan.Push.Refs[0].Ref != "refs/heads/late-branch" {
+		t.Errorf("expected only late-branch to be pushed: %+v", repoPlan.Push)
+	}
+}
+
+func TestAlignPrNumbers(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	// 1 and 5 were deleted in bitbucket
+	bb.addPr("my-repo", 2, "OPEN", "feature", "")
+	bb.addPr("my-repo", 3, "DECLINED", "feature", "")
+	bb.addPr("my-repo", 4, "MERGED", "merged-branch", runGit(t, work, "rev-parse", "main"))
+	bb.addPr("my-repo", 6, "OPEN", "deleted-branch", "")
+
+	config := testSettings(t, bb, gh)
+	config.alignPrNumbers = true
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrateRepos(ghClient, bbClient, []repoEntry{{name: "my-repo", slug: "my-repo"}}, config)
+
+	issues := gh.repo("my-repo").issues
+	if len(issues) != 6 {
+		t.Fatalf("expected 6 issues and PRs, got %d", len(issues))
+	}
+	for _, number := range []int{1, 5, 6} {
+		placeholder := issues[number-1]
+		if placeholder.IsPR || placeholder.State != "closed" || !placeholder.Locked {
+			t.Errorf("#%d is not a closed and locked placeholder: %+v", number, placeholder)
+		}
+	}
+	if placeholder := issues[4]; placeholder.Title != "Historical Bitbucket PR #5" {
+		t.Errorf("placeholder of the deleted PR has title %q", placeholder.Title)
+	}
+	if pr := issues[2]; !pr.IsPR || pr.State != "closed" {
+		t.Errorf("#3 should be the declined PR: %+v", pr)
+	}
+	if pr := issues[1]; !pr.IsPR || pr.Head != "feature" {
+		t.Errorf("#2 should be the open PR: %+v", pr)
+	}
+	if issue := issues[3]; issue.IsPR || issue.Locked || issue.Title != "Historical Bitbucket PR #4: PR number 4" {
+		t.Errorf("#4 should be the merged PR: %+v", issue)
+	}
+}
+
+func TestDiffRefs(t *testing.T) {
+	updates := diffRefs(
+		map[string]string{"refs/heads/main": "a", "refs/heads/old": "b", "refs/heads/same": "c"},
+		map[string]string{"refs/heads/main": "d", "refs/heads/new": "e", "refs/heads/same": "c"},
+	)
+	expected := []refUpdate{
+		{Ref: "refs/heads/main", Old: "a", New: "d"},
+		{Ref: "refs/heads/new", New: "e"},
+		{Ref: "refs/heads/old", Old: "b"},
+	}
+	if !slices.Equal(updates, expected) {
+		t.Errorf("got %+v, expected %+v", updates, expected)
+	}
+}
+
+func TestPlanOpenPrsWithoutRepoContents(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	bb.addRepo("my-repo", "My Repo", "PROJ")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	bb.addPr("my-repo", 2, "OPEN", "feature", "")
+
+	config := testSettings(t, bb, gh)
+	config.migrateRepoContents = false
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := planMigration(ghClient, bbClient, []repoEntry{{name: "my-repo", slug: "my-repo"}}, config).Repos[0]
+	if len(plan.PullRequests) != 0 {
+		t.Errorf("expected no PRs without branches in Github, got %+v", plan.PullRequests)
+	}
+	if len(plan.Skipped) != 1 || !strings.Contains(plan.Skipped[0], "2 open PRs") {
+		t.Errorf("expected one note for the skipped PRs, got %v", plan.Skipped)
+	}
+}
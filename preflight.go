@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/ktrysmt/go-bitbucket"
+)
+
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+)
+
+type preflightCheck struct {
+	name   string
+	status string
+	detail string
+}
+
+// runs every preflight check and prints a pass/fail table.
+// returns false if any check failed
+func runPreflight(gh *github.Client, bb *bitbucket.Client, config settings) bool {
+	var checks []preflightCheck
+	checks = append(checks, checkGit())
+	checks = append(checks, checkGithubToken(gh, config)...)
+	bbChecks := checkBitbucketCredentials(bb, config)
+	checks = append(checks, bbChecks...)
+	var repos []repoEntry
+	// the repos can only be listed once bitbucket accepts the credentials
+	if !slices.ContainsFunc(bbChecks, failed) {
+		var repoChecks []preflightCheck
+		repos, repoChecks = preflightRepos(bb, config)
+		checks = append(checks, repoChecks...)
+	}
+	if len(repos) > 0 {
+		checks = append(checks, checkCloneReachability(repos[0].slug, config))
+	}
+	for _, repo := range repos {
+		repoConfig, err := config.withOverrides(repo.overrides)
+		if err != nil {
+			checks = append(checks, preflightCheck{name: "settings for " + repo.slug, status: checkFail, detail: err.Error()})
+			continue
+		}
+		checks = append(checks, checkRepo(gh, bb, repo, repoConfig)...)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+	passed := true
+	for _, check := range checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", check.name, check.status, check.detail)
+		if failed(check) {
+			passed = false
+		}
+	}
+	w.Flush()
+	return passed
+}
+
+func failed(check preflightCheck) bool {
+	return check.status == checkFail
+}
+
+// selects the repos like the other commands do, entries of the repo file that don't resolve fail instead of stopping btg
+func preflightRepos(bb *bitbucket.Client, config settings) ([]repoEntry, []preflightCheck) {
+	if config.discoverRepos {
+		return discoverRepos(bb, config), nil
+	}
+	workspaceRepos := listWorkspaceRepos(bb, config.bbWorkspace)
+	var repos []repoEntry
+	var checks []preflightCheck
+	for _, entry := range parseRepos(config.repoFile) {
+		slug, err := resolveRepo(workspaceRepos, entry.name)
+		if err != nil {
+			checks = append(checks, preflightCheck{name: "bitbucket repo " + entry.name, status: checkFail, detail: err.Error()})
+			continue
+		}
+		entry.slug = slug
+		repos = append(repos, entry)
+	}
+	return repos, checks
+}
+
+func checkGit() preflightCheck {
+	check := preflightCheck{name: "git installed"}
+	output, err := exec.Command("git", "--version").CombinedOutput()
+	if err != nil {
+		check.status = checkFail
+		check.detail = fmt.Sprintf("could not run git: %s", err)
+		return check
+	}
+	check.status = checkPass
+	check.detail = strings.TrimSpace(string(output))
+	return check
+}
+
+func checkGithubToken(gh *github.Client, config settings) []preflightCheck {
+	if config.ghAppID != "" {
+		return checkGithubApp(gh, config)
+	}
+	ctx := context.Background()
+	tokenCheck := preflightCheck{name: "github token"}
+	user, resp, err := gh.Users.Get(ctx, "")
+	if err != nil {
+		tokenCheck.status = checkFail
+		tokenCheck.detail = err.Error()
+		return []preflightCheck{tokenCheck}
+	}
+	tokenCheck.status = checkPass
+	tokenCheck.detail = "authenticated as " + user.GetLogin()
+	checks := []preflightCheck{tokenCheck}
+
+	// classic tokens list their scopes in a header, fine-grained tokens don't
+	scopeCheck := preflightCheck{name: "github token scopes"}
+	scopes := resp.Header.Get("X-OAuth-Scopes")
+	if resp.Header.Values("X-OAuth-Scopes") == nil {
+		scopeCheck.status = checkWarn
+		scopeCheck.detail = "fine-grained token, make sure it has write access to Administration, Contents, Issues and Pull Requests"
+	} else if !slices.Contains(splitScopes(scopes), "repo") {
+		scopeCheck.status = checkFail
+		scopeCheck.detail = fmt.Sprintf("token is missing the repo scope, has: %s", scopes)
+	} else {
+		scopeCheck.status = checkPass
+		scopeCheck.detail = scopes
+	}
+	checks = append(checks, scopeCheck)
+
+	orgCheck := preflightCheck{name: "github org " + config.ghOrg}
+	membership, _, err := gh.Organizations.GetOrgMembership(ctx, "", config.ghOrg)
+	if err != nil {
+		orgCheck.status = checkFail
+		orgCheck.detail = err.Error()
+	} else if membership.GetState() != "active" {
+		orgCheck.status = checkFail
+		orgCheck.detail = "membership is " + membership.GetState()
+	} else if membership.GetRole() != "admin" {
+		orgCheck.status = checkWarn
+		orgCheck.detail = "token owner is not an org admin, repo creation and custom properties may fail"
+	} else {
+		orgCheck.status = checkPass
+		orgCheck.detail = "token owner is an org admin"
+	}
+	return append(checks, orgCheck)
+}
+
+// installation tokens can't look up users or org membership, so we check the permissions
+// the installation was granted instead
+func checkGithubApp(gh *github.Client, config settings) []preflightCheck {
+	tokenCheck := preflightCheck{name: "github app installation token"}
+	_, app, err := newGithubAppTokenSource(config)
+	if err == nil {
+		_, err = app.Token()
+	}
+	if err != nil {
+		tokenCheck.status = checkFail
+		tokenCheck.detail = err.Error()
+		return []preflightCheck{tokenCheck}
+	}
+	tokenCheck.status = checkPass
+	tokenCheck.detail = "created a token for installation " + config.ghAppInstallationID
+
+	permissionCheck := preflightCheck{name: "github app permissions"}
+	required := map[string]string{
+		"Administration": app.permissions.GetAdministration(),
+		"Contents":       app.permissions.GetContents(),
+		"Issues":         app.permissions.GetIssues(),
+		"Pull Requests":  app.permissions.GetPullRequests(),
+	}
+	var missing []string
+	for permission, access := range required {
+		if access != "write" && access != "admin" {
+			missing = append(missing, permission)
+		}
+	}
+	slices.Sort(missing)
+	if len(missing) > 0 {
+		permissionCheck.status = checkFail
+		permissionCheck.detail = "app needs write access to " + strings.Join(missing, ", ")
+	} else {
+		permissionCheck.status = checkPass
+		permissionCheck.detail = "app has write access to Administration, Contents, Issues and Pull Requests"
+	}
+
+	orgCheck := preflightCheck{name: "github org " + config.ghOrg}
+	_, _, err = gh.Organizations.Get(context.Background(), config.ghOrg)
+	if err != nil {
+		orgCheck.status = checkFail
+		orgCheck.detail = err.Error()
+	} else {
+		orgCheck.status = checkPass
+		orgCheck.detail = "org is accessible"
+	}
+	return []preflightCheck{tokenCheck, permissionCheck, orgCheck}
+}
+
+func splitScopes(scopes string) []string {
+	var result []string
+	for _, scope := range strings.Split(scopes, ",") {
+		result = append(result, strings.TrimSpace(scope))
+	}
+	return result
+}
+
+func checkBitbucketCredentials(bb *bitbucket.Client, config settings) []preflightCheck {
+	if config.bbTokenSource != nil {
+		// access tokens and OAuth consumers don't belong to a user, so they can't get a profile
+		return []preflightCheck{checkBitbucketWorkspace(bb, config)}
+	}
+	userCheck := preflightCheck{name: "bitbucket credentials"}
+	user, err := bb.User.Profile()
+	if err != nil {
+		userCheck.status = checkFail
+		userCheck.detail = err.Error()
+		return []preflightCheck{userCheck}
+	}
+	userCheck.status = checkPass
+	userCheck.detail = "authenticated as " + user.DisplayName
+	return []preflightCheck{userCheck, checkBitbucketWorkspace(bb, config)}
+}
+
+func checkBitbucketWorkspace(bb *bitbucket.Client, config settings) preflightCheck {
+	workspaceCheck := preflightCheck{name: "bitbucket workspace " + config.bbWorkspace}
+	_, err := bb.Workspaces.Get(config.bbWorkspace)
+	if err != nil {
+		workspaceCheck.status = checkFail
+		workspaceCheck.detail = err.Error()
+	} else {
+		workspaceCheck.status = checkPass
+		workspaceCheck.detail = "workspace is accessible"
+	}
+	return workspaceCheck
+}
+
+// runs git ls-remote against repo, which needs the same access as git clone
+func checkCloneReachability(repo string, config settings) preflightCheck {
+	check := preflightCheck{name: fmt.Sprintf("git clone via %s", config.cloneVia)}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", bitbucketCloneURL(repo, config))
+	env, cleanup := gitAuthEnv(bitbucketGitCredentials(config))
+	defer cleanup()
+	// fail instead of hanging on a host key prompt
+	cmd.Env = append(env, "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		check.status = checkFail
+		check.detail = fmt.Sprintf("could not reach %s: %s", repo, strings.TrimSpace(string(output)))
+		return check
+	}
+	check.status = checkPass
+	check.detail = "reached " + repo
+	return check
+}
+
+func checkRepo(gh *github.Client, bb *bitbucket.Client, repo repoEntry, config settings) []preflightCheck {
+	existsCheck := preflightCheck{name: "bitbucket repo " + repo.slug}
+	bbRepo, err := findRepo(bb, config.bbWorkspace, repo.slug)
+	if err != nil {
+		existsCheck.status = checkFail
+		existsCheck.detail = err.Error()
+		return []preflightCheck{existsCheck}
+	}
+	existsCheck.status = checkPass
+	existsCheck.detail = "found " + bbRepo.Full_name
+
+	ghName := githubRepoName(repo, bbRepo, config)
+	collisionCheck := preflightCheck{name: fmt.Sprintf("github repo %s/%s", config.ghOrg, ghName)}
+	_, resp, err := gh.Repositories.Get(context.Background(), config.ghOrg, ghName)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			collisionCheck.status = checkPass
+			collisionCheck.detail = "name is free"
+		} else {
+			collisionCheck.status = checkFail
+			collisionCheck.detail = err.Error()
+		}
+	} else if config.overwrite {
+		collisionCheck.status = checkWarn
+		collisionCheck.detail = "repo already exists and will be overwritten"
+	} else {
+		collisionCheck.status = checkFail
+		collisionCheck.detail = "repo already exists and GITHUB_OVERWRITE is false"
+	}
+	return []preflightCheck{existsCheck, collisionCheck}
+}
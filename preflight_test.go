@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreflightReposWithUnknownEntry(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	bb.addRepo("my-repo", "My Repo", "PROJ")
+
+	config := testSettings(t, bb, gh)
+	config.repoFile = filepath.Join(t.TempDir(), "repos.txt")
+	if err := os.WriteFile(config.repoFile, []byte("My Repo\nmissing-repo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos, checks := preflightRepos(bbClient, config)
+	if len(repos) != 1 || repos[0].slug != "my-repo" {
+		t.Errorf("expected my-repo to resolve, got %+v", repos)
+	}
+	if len(checks) != 1 || checks[0].name != "bitbucket repo missing-repo" || checks[0].status != checkFail {
+		t.Errorf("expected a failed check for missing-repo, got %+v", checks)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// remembers where every bitbucket repo and PR ended up in Github, so links to them can be rewritten.
+// it is kept in REF_MAP_FILE, which lets links to repos migrated in an earlier run be rewritten too
+type referenceMap struct {
+	// keyed by workspace/slug, lowercase
+	Repos map[string]*migratedRepo `json:"repos"`
+
+	path     string
+	links    *regexp.Regexp
+	ghWebURL string
+}
+
+type migratedRepo struct {
+	// org/name
+	Github string `json:"github"`
+	// keyed by bitbucket PR id
+	PullRequests map[int]migratedPullRequest `json:"pull_requests"`
+}
+
+type migratedPullRequest struct {
+	Number int `json:"number"`
+	// merged PRs are migrated as issues
+	Issue bool `json:"issue,omitempty"`
+}
+
+func loadReferenceMap(config settings) *referenceMap {
+	refs := &referenceMap{
+		Repos:    map[string]*migratedRepo{},
+		path:     config.refMapFile,
+		links:    regexp.MustCompile(`https?://(?:www\.)?` + regexp.QuoteMeta(bitbucketHost(config)) + `/([\w.-]+)/([\w.-]+)` + bitbucketLinkPath),
+		ghWebURL: githubWebURL(config),
+	}
+	data, err := os.ReadFile(config.refMapFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return refs
+	}
+	if err != nil {
+		log.Fatalf("could not read %s: %s", config.refMapFile, err)
+	}
+	err = json.Unmarshal(data, refs)
+	if err != nil {
+		log.Fatalf("invalid %s: %s", config.refMapFile, err)
+	}
+	return refs
+}
+
+func (r *referenceMap) save() {
+	err := writeJSONFile(r.path, r)
+	if err != nil {
+		log.Fatalf("could not write %s: %s", r.path, err)
+	}
+}
+
+func repoKey(workspace string, slug string) string {
+	return strings.ToLower(workspace + "/" + slug)
+}
+
+func (r *referenceMap) addRepo(plan repoPlan) *migratedRepo {
+	key := repoKey(plan.Workspace, plan.Slug)
+	repo, ok := r.Repos[key]
+	if !ok {
+		repo = &migratedRepo{PullRequests: map[int]migratedPullRequest{}}
+		r.Repos[key] = repo
+	}
+	repo.Github = plan.GithubOrg + "/" + plan.GithubName
+	return repo
+}
+
+var (
+	// the path of a bitbucket link, after workspace/slug. trailing punctuation is left out
+	bitbucketLinkPath = `(/[^\s()<>\[\]"'` + "`" + `]*[^\s()<>\[\]"'.,;:!?` + "`" + `])?`
+	bitbucketPrLink   = regexp.MustCompile(`^/pull-requests/(\d+)(?:/[^?#]*)?(?:[?#].*)?$`)
+	bitbucketCommit   = regexp.MustCompile(`^/commits?/([0-9a-fA-F]{7,40})(?:[?#].*)?$`)
+	bitbucketBranch   = regexp.MustCompile(`^/branch/([^?#]+)(?:[?#].*)?$`)
+	bitbucketSrc      = regexp.MustCompile(`^/src/([^?#]+)(?:[?#].*)?$`)
+	// "PR #42" and "pull request #42", the way bitbucket refers to PRs in the same repo
+	bitbucketPrShortRef = regexp.MustCompile(`(?i)\b(PR|pull request) #(\d+)\b`)
+)
+
+// rewrites links to migrated bitbucket repos, PRs, commits and branches, and short PR references,
+// in text from the repo workspace/slug so they point at Github. everything else is left as is
+func (r *referenceMap) rewrite(text string, workspace string, slug string) string {
+	text = r.links.ReplaceAllStringFunc(text, func(link string) string {
+		match := r.links.FindStringSubmatch(link)
+		repo, ok := r.Repos[repoKey(match[1], strings.TrimSuffix(match[2], ".git"))]
+		if !ok {
+			return link
+		}
+		if rewritten, ok := r.rewritePath(repo, match[3]); ok {
+			return rewritten
+		}
+		return link
+	})
+
+	repo, ok := r.Repos[repoKey(workspace, slug)]
+	if !ok {
+		return text
+	}
+	return bitbucketPrShortRef.ReplaceAllStringFunc(text, func(ref string) string {
+		match := bitbucketPrShortRef.FindStringSubmatch(ref)
+		id, _ := strconv.Atoi(match[2])
+		migrated, ok := repo.PullRequests[id]
+		if !ok {
+			return ref
+		}
+		return fmt.Sprintf("%s #%d", match[1], migrated.Number)
+	})
+}
+
+// returns the Github url for the path of a bitbucket link, false if there is none
+func (r *referenceMap) rewritePath(repo *migratedRepo, path string) (string, bool) {
+	repoURL := r.ghWebURL + "/" + repo.Github
+	if path == "" || path == "/" {
+		return repoURL, true
+	}
+	if match := bitbucketPrLink.FindStringSubmatch(path); match != nil {
+		id, _ := strconv.Atoi(match[1])
+		migrated, ok := repo.PullRequests[id]
+		if !ok {
+			return "", false
+		}
+		if migrated.Issue {
+			return fmt.Sprintf("%s/issues/%d", repoURL, migrated.Number), true
+		}
+		return fmt.Sprintf("%s/pull/%d", repoURL, migrated.Number), true
+	}
+	if match := bitbucketCommit.FindStringSubmatch(path); match != nil {
+		return repoURL + "/commit/" + match[1], true
+	}
+	if match := bitbucketBranch.FindStringSubmatch(path); match != nil {
+		return repoURL + "/tree/" + match[1], true
+	}
+	if match := bitbucketSrc.FindStringSubmatch(path); match != nil {
+		return repoURL + "/tree/" + match[1], true
+	}
+	return "", false
+}
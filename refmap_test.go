@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRewriteReferences(t *testing.T) {
+	refs := loadReferenceMap(settings{bbURL: "https://bitbucket.org", refMapFile: "missing.json"})
+	refs.Repos["ws/app"] = &migratedRepo{
+		Github: "org/app",
+		PullRequests: map[int]migratedPullRequest{
+			42: {Number: 3},
+			43: {Number: 4, Issue: true},
+		},
+	}
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"see PR #42 and pull request #43", "see PR #3 and pull request #4"},
+		{"PR #44 wasn't migrated", "PR #44 wasn't migrated"},
+		{"https://bitbucket.org/ws/app/pull-requests/42.", "https://github.com/org/app/pull/3."},
+		{"(https://bitbucket.org/ws/app/pull-requests/43/diff)", "(https://github.com/org/app/issues/4)"},
+		{"https://bitbucket.org/ws/app/commits/0123456789abcdef", "https://github.com/org/app/commit/0123456789abcdef"},
+		{"https://bitbucket.org/ws/app/branch/feature/login", "https://github.com/org/app/tree/feature/login"},
+		{"https://bitbucket.org/ws/app/src/main/README.md", "https://github.com/org/app/tree/main/README.md"},
+		{"[app](https://bitbucket.org/ws/app)", "[app](https://github.com/org/app)"},
+		// not migrated yet
+		{"https://bitbucket.org/ws/other/pull-requests/42", "https://bitbucket.org/ws/other/pull-requests/42"},
+		{"https://bitbucket.org/ws/app/pull-requests/44", "https://bitbucket.org/ws/app/pull-requests/44"},
+	}
+	for _, test := range tests {
+		if actual := refs.rewrite(test.text, "ws", "app"); actual != test.expected {
+			t.Errorf("rewrite(%q) = %q, expected %q", test.text, actual, test.expected)
+		}
+	}
+	// short references only mean something in their own repo
+	if actual := refs.rewrite("PR #42", "ws", "other"); actual != "PR #42" {
+		t.Errorf("short reference from another repo was rewritten to %q", actual)
+	}
+}
+
+func TestMigrateRewritesReferences(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	mergeCommit := runGit(t, work, "rev-parse", "main")
+	bb.addPr("my-repo", 5, "OPEN", "feature", "")
+	bb.addPr("my-repo", 7, "MERGED", "merged-branch", mergeCommit)
+	bb.prs["my-repo"][0]["summary"] = map[string]any{
+		"raw": fmt.Sprintf("follow up to PR #7, see %s/%s/my-repo/pull-requests/7", bb.server.URL, fakeWorkspace),
+	}
+
+	config := testSettings(t, bb, gh)
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos, problems := resolveRepos(bbClient, config.bbWorkspace, []repoEntry{{name: "my-repo"}})
+	if len(problems) > 0 {
+		t.Fatalf("could not resolve repos: %v", problems)
+	}
+	migrateRepos(ghClient, bbClient, repos, config)
+
+	// PR 7 is created after PR 5, so its reference is only fixed after both exist
+	pr := gh.repo("my-repo").issues[0]
+	expected := fmt.Sprintf("follow up to PR #2, see %s/%s/my-repo/issues/2", githubWebURL(config), fakeGithubOrg)
+	if !strings.Contains(pr.Body, expected) {
+		t.Errorf("references were not rewritten: %s", pr.Body)
+	}
+
+	refs := loadReferenceMap(config)
+	if migrated := refs.Repos[fakeWorkspace+"/my-repo"].PullRequests[7]; migrated.Number != 2 || !migrated.Issue {
+		t.Errorf("unexpected reference map entry for PR 7: %+v", migrated)
+	}
+	if _, err := os.Stat(config.refMapFile); err != nil {
+		t.Errorf("reference map was not saved: %s", err)
+	}
+}
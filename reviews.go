@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// who reviewed a bitbucket PR
+type prReviews struct {
+	// the users asked to review, as returned by the bitbucket api
+	Reviewers []map[string]any
+	Events    []reviewEvent
+}
+
+// an approval or change request on a bitbucket PR
+type reviewEvent struct {
+	User map[string]any
+	// approved or requested changes
+	Action string
+	Date   string
+}
+
+// returns the reviewers of the PR and its approvals and change requests.
+// the PR list doesn't include reviewers and participants, so the PR and its activity are fetched
+func getPrReviews(config settings, slug string, id int) prReviews {
+	prPath := fmt.Sprintf("%s/pullrequests/%d", bitbucketRepoPath(config, slug), id)
+	pr, err := bitbucketGet(config, prPath)
+	if err != nil {
+		log.Fatalf("Failed to get PR %d of %s: %s", id, slug, err)
+	}
+	activity, err := bitbucketGetAll(config, prPath+"/activity")
+	if err != nil {
+		log.Fatalf("Failed to get activity of PR %d of %s: %s", id, slug, err)
+	}
+	reviews, err := parsePrReviews(pr, activity)
+	if err != nil {
+		log.Fatalf("Failed to read reviews of PR %d of %s: %s", id, slug, err)
+	}
+	return reviews
+}
+
+// reads the reviews from the api json of a PR and its activity
+func parsePrReviews(pr json.RawMessage, activity []json.RawMessage) (prReviews, error) {
+	var fields struct {
+		Reviewers    []map[string]any `json:"reviewers"`
+		Participants []struct {
+			User           map[string]any `json:"user"`
+			Approved       bool           `json:"approved"`
+			State          string         `json:"state"`
+			ParticipatedOn string         `json:"participated_on"`
+		} `json:"participants"`
+	}
+	err := json.Unmarshal(pr, &fields)
+	if err != nil {
+		return prReviews{}, err
+	}
+	reviews := prReviews{Reviewers: fields.Reviewers}
+
+	// activity has every approval and change request with its date
+	for _, raw := range activity {
+		var entry struct {
+			Approval *struct {
+				Date string         `json:"date"`
+				User map[string]any `json:"user"`
+			} `json:"approval"`
+			ChangesRequest *struct {
+				Date string         `json:"date"`
+				User map[string]any `json:"user"`
+			} `json:"changes_request"`
+		}
+		err = json.Unmarshal(raw, &entry)
+		if err != nil {
+			return prReviews{}, err
+		}
+		if entry.Approval != nil {
+			reviews.Events = append(reviews.Events, reviewEvent{User: entry.Approval.User, Action: "approved", Date: entry.Approval.Date})
+		}
+		if entry.ChangesRequest != nil {
+			reviews.Events = append(reviews.Events, reviewEvent{User: entry.ChangesRequest.User, Action: "requested changes", Date: entry.ChangesRequest.Date})
+		}
+	}
+
+	// participants have the current state, which covers activity bitbucket no longer returns
+	for _, participant := range fields.Participants {
+		action := ""
+		if participant.Approved {
+			action = "approved"
+		} else if participant.State == "changes_requested" {
+			action = "requested changes"
+		}
+		if action == "" || slices.ContainsFunc(reviews.Events, func(event reviewEvent) bool {
+			return event.Action == action && sameBitbucketUser(event.User, participant.User)
+		}) {
+			continue
+		}
+		reviews.Events = append(reviews.Events, reviewEvent{User: participant.User, Action: action, Date: participant.ParticipatedOn})
+	}
+	slices.SortStableFunc(reviews.Events, func(a, b reviewEvent) int { return strings.Compare(a.Date, b.Date) })
+	return reviews, nil
+}
+
+func sameBitbucketUser(a map[string]any, b map[string]any) bool {
+	for _, key := range []string{"account_id", "uuid", "nickname", "display_name"} {
+		if id := jsonString(a, key); id != "" {
+			return id == jsonString(b, key)
+		}
+	}
+	return false
+}
+
+// maps bitbucket accounts to github logins, read from USER_MAP_FILE
+type userMap map[string]string
+
+// reads a CSV with a bitbucket and a github column. bitbucket can be an account id, uuid or nickname
+func loadUserMap(path string) userMap {
+	users := userMap{}
+	if path == "" {
+		return users
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("could not read USER_MAP_FILE %s: %s", path, err)
+	}
+	rows, err := parseCsvManifest(data)
+	if err != nil {
+		log.Fatalf("invalid USER_MAP_FILE %s: %s", path, err)
+	}
+	for i, row := range rows {
+		account, login := strings.TrimSpace(row["bitbucket"]), strings.TrimSpace(row["github"])
+		if account == "" || login == "" {
+			log.Fatalf("USER_MAP_FILE %s row %d needs a bitbucket and a github column", path, i+1)
+		}
+		users[strings.ToLower(account)] = strings.TrimPrefix(login, "@")
+	}
+	return users
+}
+
+// returns the github login of the bitbucket user, empty if it isn't mapped
+func (users userMap) login(user map[string]any) string {
+	for _, key := range []string{"account_id", "uuid", "nickname"} {
+		if id := jsonString(user, key); id != "" {
+			if login, ok := users[strings.ToLower(id)]; ok {
+				return login
+			}
+		}
+	}
+	return ""
+}
+
+// plans the review requests of the open PRs and the approval log of every migrated PR
+func planReviews(plan *repoPlan, src source, users userMap) {
+	for i := range plan.PullRequests {
+		pr := &plan.PullRequests[i]
+		reviews := src.getPrReviews(plan.Slug, pr.BitbucketID)
+		pr.ApprovalLog = approvalLog(reviews.Events, users)
+		if pr.State != "" {
+			continue
+		}
+		for _, reviewer := range reviews.Reviewers {
+			login := users.login(reviewer)
+			if login == "" {
+				plan.Skipped = append(plan.Skipped, fmt.Sprintf("PR #%d: review request for %s, who has no Github login in USER_MAP_FILE", pr.BitbucketID, jsonString(reviewer, "display_name")))
+				continue
+			}
+			pr.Reviewers = append(pr.Reviewers, login)
+		}
+	}
+	for i := range plan.Issues {
+		issue := &plan.Issues[i]
+		issue.ApprovalLog = approvalLog(src.getPrReviews(plan.Slug, issue.BitbucketID).Events, users)
+	}
+}
+
+// the comment that records who approved or requested changes and when, empty if nobody did
+func approvalLog(events []reviewEvent, users userMap) string {
+	if len(events) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	text.WriteString("**Reviews in Bitbucket**\n\n")
+	for _, event := range events {
+		name := jsonString(event.User, "display_name")
+		if login := users.login(event.User); login != "" {
+			name += " (" + login + ")"
+		}
+		fmt.Fprintf(&text, "- %s %s on %s\n", name, event.Action, formatBitbucketTime(event.Date))
+	}
+	return text.String()
+}
+
+func formatBitbucketTime(timestamp string) string {
+	parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return parsed.UTC().Format("2006-01-02 15:04 UTC")
+}
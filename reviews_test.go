@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePrReviews(t *testing.T) {
+	pr := json.RawMessage(`{
+		"reviewers": [{"display_name": "Ann", "uuid": "{ann}"}, {"display_name": "Bob", "nickname": "bob"}],
+		"participants": [
+			{"user": {"display_name": "Ann", "uuid": "{ann}"}, "approved": true, "participated_on": "2024-01-02T10:00:00+00:00"},
+			{"user": {"display_name": "Bob", "nickname": "bob"}, "state": "changes_requested", "participated_on": "2024-01-01T09:30:00+00:00"},
+			{"user": {"display_name": "Cat", "nickname": "cat"}, "participated_on": "2024-01-01T08:00:00+00:00"}
+		]
+	}`)
+	activity := []json.RawMessage{
+		json.RawMessage(`{"approval": {"date": "2024-01-02T10:00:00.123+00:00", "user": {"display_name": "Ann", "uuid": "{ann}"}}}`),
+		json.RawMessage(`{"comment": {"id": 1}}`),
+	}
+	reviews, err := parsePrReviews(pr, activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reviews.Reviewers) != 2 {
+		t.Errorf("expected 2 reviewers, got %v", reviews.Reviewers)
+	}
+
+	users := userMap{"{ann}": "ann-gh"}
+	expected := "**Reviews in Bitbucket**\n\n" +
+		"- Bob requested changes on 2024-01-01 09:30 UTC\n" +
+		"- Ann (ann-gh) approved on 2024-01-02 10:00 UTC\n"
+	if actual := approvalLog(reviews.Events, users); actual != expected {
+		t.Errorf("unexpected approval log:\n%s", actual)
+	}
+	if actual := approvalLog(nil, users); actual != "" {
+		t.Errorf("expected no approval log without reviews, got %q", actual)
+	}
+}
+
+func TestMigrateReviews(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	work := bb.addRepo("my-repo", "My Repo", "PROJ")
+	mergeCommit := runGit(t, work, "rev-parse", "main")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	bb.addPr("my-repo", 2, "MERGED", "merged-branch", mergeCommit)
+	ann := map[string]any{"display_name": "Ann", "account_id": "557058:ann"}
+	bb.prs["my-repo"][0]["reviewers"] = []any{ann, map[string]any{"display_name": "Bob", "nickname": "bob"}}
+	bb.addPrApproval("my-repo", 1, ann)
+	bb.addPrApproval("my-repo", 2, ann)
+
+	config := testSettings(t, bb, gh)
+	config.userMapFile = filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(config.userMapFile, []byte("bitbucket,github\n557058:ann,@ann-gh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := []repoEntry{{name: "my-repo", slug: "my-repo"}}
+	plan := planMigration(ghClient, bbClient, repos, config)
+	if skipped := plan.Repos[0].Skipped; !slices.ContainsFunc(skipped, func(s string) bool { return strings.Contains(s, "Bob") }) {
+		t.Errorf("expected the unmapped reviewer to be skipped, got %v", skipped)
+	}
+	migrateRepos(ghClient, bbClient, repos, config)
+
+	repo := gh.repo("my-repo")
+	if len(repo.issues) != 2 {
+		t.Fatalf("expected 2 PRs, got %d", len(repo.issues))
+	}
+	pr, merged := repo.issues[0], repo.issues[1]
+	if !slices.Equal(pr.Reviewers, []string{"ann-gh"}) {
+		t.Errorf("expected a review request for ann-gh, got %v", pr.Reviewers)
+	}
+	for _, migrated := range []*fakeIssue{pr, merged} {
+		if len(migrated.Comments) != 1 || !strings.Contains(migrated.Comments[0], "- Ann (ann-gh) approved on") {
+			t.Errorf("expected an approval log on #%d, got %v", migrated.Number, migrated.Comments)
+		}
+	}
+}
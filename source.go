@@ -0,0 +1,153 @@
+package main
+
+import (
+	"github.com/google/go-github/v72/github"
+	"github.com/ktrysmt/go-bitbucket"
+)
+
+// where repos are migrated from
+type source interface {
+	getRepo(slug string) *bitbucket.Repository
+	// returns the branches and tags of the repo, mapped to their commit hash
+	listRefs(slug string) map[string]string
+	// returns the changes that make every user and group permission read only
+	listPermissionChanges(slug string) []permissionChange
+	setPermission(slug string, change permissionChange)
+	// returns the path of a local mirror clone
+	cloneRepo(slug string) string
+	getPrs(slug string) *PullRequests
+	// fetches branch of the fork, a workspace/slug, into ref of the local mirror clone
+	fetchFork(repoFolder string, fork string, branch string, ref string) error
+	getPrReviews(slug string, id int) prReviews
+	getPrTasks(slug string, id int) []prTask
+	downloadAttachment(link string) ([]byte, error)
+}
+
+// where repos are migrated to
+type target interface {
+	// returns nil if the repo doesn't exist
+	findRepo(name string) *github.Repository
+	// returns the branches and tags of the repo, mapped to their commit hash
+	listRefs(name string) map[string]string
+	createRepo(plan repoPlan)
+	pushRefs(repoFolder string, plan repoPlan)
+	updateRepoSettings(plan repoPlan)
+	// the create functions return the Github number of the PR or issue.
+	// createPullRequest returns false if Github refused the PR, eg because its branch is gone
+	createPullRequest(plan repoPlan, pr plannedPullRequest) (int, bool)
+	createIssue(plan repoPlan, issue plannedIssue) int
+	createPlaceholder(plan repoPlan, placeholder plannedPlaceholder) int
+	updateBody(plan repoPlan, number int, body string)
+	requestReviews(plan repoPlan, number int, logins []string)
+	addComment(plan repoPlan, number int, body string)
+	// commits the files to attachmentsBranch and returns their urls by path
+	uploadAttachments(plan repoPlan, files map[string][]byte) map[string]string
+}
+
+type bitbucketSource struct {
+	client *bitbucket.Client
+	config settings
+}
+
+func newBitbucketSource(client *bitbucket.Client, config settings) *bitbucketSource {
+	return &bitbucketSource{client: client, config: config}
+}
+
+func (s *bitbucketSource) getRepo(slug string) *bitbucket.Repository {
+	return getRepo(s.client, s.config.bbWorkspace, slug)
+}
+
+func (s *bitbucketSource) listRefs(slug string) map[string]string {
+	return mustListRemoteRefs(bitbucketCloneURL(slug, s.config), bitbucketGitCredentials(s.config))
+}
+
+func (s *bitbucketSource) listPermissionChanges(slug string) []permissionChange {
+	return listReadOnlyPermissionChanges(s.client, s.config.bbWorkspace, slug)
+}
+
+func (s *bitbucketSource) setPermission(slug string, change permissionChange) {
+	setPermission(s.client, s.config.bbWorkspace, slug, change)
+}
+
+func (s *bitbucketSource) cloneRepo(slug string) string {
+	return cloneRepo(slug, s.config)
+}
+
+func (s *bitbucketSource) getPrs(slug string) *PullRequests {
+	return getPrs(s.client, s.config.bbWorkspace, slug)
+}
+
+func (s *bitbucketSource) fetchFork(repoFolder string, fork string, branch string, ref string) error {
+	return fetchForkBranch(repoFolder, fork, branch, ref, s.config)
+}
+
+func (s *bitbucketSource) getPrReviews(slug string, id int) prReviews {
+	return getPrReviews(s.config, slug, id)
+}
+
+func (s *bitbucketSource) getPrTasks(slug string, id int) []prTask {
+	return getPrTasks(s.config, slug, id)
+}
+
+func (s *bitbucketSource) downloadAttachment(link string) ([]byte, error) {
+	return downloadAttachment(s.config, link)
+}
+
+type githubTarget struct {
+	client *github.Client
+	config settings
+}
+
+func newGithubTarget(client *github.Client, config settings) *githubTarget {
+	return &githubTarget{client: client, config: config}
+}
+
+func (t *githubTarget) findRepo(name string) *github.Repository {
+	return findGithubRepo(t.client, t.config.ghOrg, name)
+}
+
+func (t *githubTarget) listRefs(name string) map[string]string {
+	return mustListRemoteRefs(githubCloneURL(name, t.config), githubGitCredentials(t.config))
+}
+
+func (t *githubTarget) createRepo(plan repoPlan) {
+	createRepo(t.client, plan)
+}
+
+func (t *githubTarget) pushRefs(repoFolder string, plan repoPlan) {
+	pushRefsToGithub(repoFolder, plan.GithubName, plan.Push, t.config)
+}
+
+func (t *githubTarget) updateRepoSettings(plan repoPlan) {
+	updateRepo(t.client, plan)
+	updateRepoTopics(t.client, plan)
+	updateCustomProperties(t.client, plan)
+}
+
+func (t *githubTarget) createPullRequest(plan repoPlan, pr plannedPullRequest) (int, bool) {
+	return createPullRequest(t.client, plan, pr)
+}
+
+func (t *githubTarget) createIssue(plan repoPlan, issue plannedIssue) int {
+	return createClosedIssue(t.client, plan, issue)
+}
+
+func (t *githubTarget) createPlaceholder(plan repoPlan, placeholder plannedPlaceholder) int {
+	return createPlaceholderIssue(t.client, plan, placeholder)
+}
+
+func (t *githubTarget) updateBody(plan repoPlan, number int, body string) {
+	updateIssueBody(t.client, plan, number, body)
+}
+
+func (t *githubTarget) requestReviews(plan repoPlan, number int, logins []string) {
+	requestReviewers(t.client, plan, number, logins)
+}
+
+func (t *githubTarget) addComment(plan repoPlan, number int, body string) {
+	addIssueComment(t.client, plan, number, body)
+}
+
+func (t *githubTarget) uploadAttachments(plan repoPlan, files map[string][]byte) map[string]string {
+	return uploadGithubAttachments(t.client, plan, files, t.config)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// a task on a bitbucket PR, the review action items github has no equivalent for
+type prTask struct {
+	Content  string
+	Creator  string
+	Resolved bool
+	// where the task was created from, empty for tasks on the PR itself
+	Context string
+}
+
+// returns the tasks of the PR with the comments they were created on
+func getPrTasks(config settings, slug string, id int) []prTask {
+	prPath := fmt.Sprintf("%s/pullrequests/%d", bitbucketRepoPath(config, slug), id)
+	tasks, err := bitbucketGetAll(config, prPath+"/tasks")
+	if err != nil {
+		log.Fatalf("Failed to get tasks of PR %d of %s: %s", id, slug, err)
+	}
+	comments, err := bitbucketGetAll(config, prPath+"/comments")
+	if err != nil {
+		log.Fatalf("Failed to get comments of PR %d of %s: %s", id, slug, err)
+	}
+	parsed, err := parsePrTasks(tasks, comments)
+	if err != nil {
+		log.Fatalf("Failed to read tasks of PR %d of %s: %s", id, slug, err)
+	}
+	return parsed
+}
+
+// reads the tasks from the api json of a PR's tasks and comments
+func parsePrTasks(tasks []json.RawMessage, comments []json.RawMessage) ([]prTask, error) {
+	contexts := map[int]string{}
+	for _, raw := range comments {
+		var comment struct {
+			ID      int `json:"id"`
+			Content struct {
+				Raw string `json:"raw"`
+			} `json:"content"`
+			Inline *struct {
+				Path string `json:"path"`
+				To   int    `json:"to"`
+				From int    `json:"from"`
+			} `json:"inline"`
+		}
+		err := json.Unmarshal(raw, &comment)
+		if err != nil {
+			return nil, err
+		}
+		context := fmt.Sprintf("on comment %q", excerpt(comment.Content.Raw, 80))
+		if comment.Inline != nil {
+			line := comment.Inline.To
+			if line == 0 {
+				line = comment.Inline.From
+			}
+			context += fmt.Sprintf(" at `%s` line %d", comment.Inline.Path, line)
+		}
+		contexts[comment.ID] = context
+	}
+
+	var parsed []prTask
+	for _, raw := range tasks {
+		var task struct {
+			State   string `json:"state"`
+			Content struct {
+				Raw string `json:"raw"`
+			} `json:"content"`
+			Creator map[string]any `json:"creator"`
+			Comment *struct {
+				ID int `json:"id"`
+			} `json:"comment"`
+		}
+		err := json.Unmarshal(raw, &task)
+		if err != nil {
+			return nil, err
+		}
+		planned := prTask{
+			Content:  excerpt(task.Content.Raw, 0),
+			Creator:  jsonString(task.Creator, "display_name"),
+			Resolved: task.State == "RESOLVED",
+		}
+		if task.Comment != nil {
+			planned.Context = contexts[task.Comment.ID]
+		}
+		parsed = append(parsed, planned)
+	}
+	return parsed, nil
+}
+
+// text on a single line, cut to at most length runes when length isn't 0
+func excerpt(text string, length int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); length > 0 && len(runes) > length {
+		return string(runes[:length-1]) + "…"
+	}
+	return text
+}
+
+// adds the tasks of the PRs that have any to their body as a github task list
+func planTasks(plan *repoPlan, src source, prs []PullRequest, markdown *markdownConverter) {
+	taskCounts := map[int]int{}
+	for _, pr := range prs {
+		taskCounts[pr.ID] = pr.TaskCount
+	}
+	for i := range plan.PullRequests {
+		pr := &plan.PullRequests[i]
+		if taskCounts[pr.BitbucketID] > 0 {
+			pr.Body += taskList(convertTasks(src.getPrTasks(plan.Slug, pr.BitbucketID), markdown))
+		}
+	}
+	for i := range plan.Issues {
+		issue := &plan.Issues[i]
+		if taskCounts[issue.BitbucketID] > 0 {
+			issue.Body += taskList(convertTasks(src.getPrTasks(plan.Slug, issue.BitbucketID), markdown))
+		}
+	}
+}
+
+func convertTasks(tasks []prTask, markdown *markdownConverter) []prTask {
+	for i := range tasks {
+		tasks[i].Content = markdown.convert(tasks[i].Content)
+		tasks[i].Context = markdown.convert(tasks[i].Context)
+	}
+	return tasks
+}
+
+// the markdown checklist of the tasks, empty if there are none
+func taskList(tasks []prTask) string {
+	if len(tasks) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	text.WriteString("\n\n**Tasks in Bitbucket**\n\n")
+	for _, task := range tasks {
+		check := " "
+		if task.Resolved {
+			check = "x"
+		}
+		details := "created by " + task.Creator
+		if task.Context != "" {
+			details += ", " + task.Context
+		}
+		fmt.Fprintf(&text, "- [%s] %s (%s)\n", check, task.Content, details)
+	}
+	return text.String()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTaskList(t *testing.T) {
+	tasks := []json.RawMessage{
+		json.RawMessage(`{"state": "RESOLVED", "content": {"raw": "fix the\ntypo"}, "creator": {"display_name": "Ann"}, "comment": {"id": 7}}`),
+		json.RawMessage(`{"state": "UNRESOLVED", "content": {"raw": "add tests"}, "creator": {"display_name": "Bob"}}`),
+	}
+	comments := []json.RawMessage{
+		json.RawMessage(`{"id": 7, "content": {"raw": "teh is a typo"}, "inline": {"path": "main.go", "to": 12}}`),
+	}
+	parsed, err := parsePrTasks(tasks, comments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\n\n**Tasks in Bitbucket**\n\n" +
+		"- [x] fix the typo (created by Ann, on comment \"teh is a typo\" at `main.go` line 12)\n" +
+		"- [ ] add tests (created by Bob)\n"
+	if actual := taskList(parsed); actual != expected {
+		t.Errorf("unexpected task list:\n%s", actual)
+	}
+	if actual := taskList(nil); actual != "" {
+		t.Errorf("expected no task list without tasks, got %q", actual)
+	}
+	if actual := excerpt(strings.Repeat("a", 100), 10); actual != "aaaaaaaaa…" {
+		t.Errorf("unexpected excerpt %q", actual)
+	}
+}
+
+func TestMigratePrTasks(t *testing.T) {
+	bb := newFakeBitbucket(t)
+	gh := newFakeGithub(t)
+	bb.addRepo("my-repo", "My Repo", "PROJ")
+	bb.addPr("my-repo", 1, "OPEN", "feature", "")
+	bb.addPr("my-repo", 2, "OPEN", "feature", "")
+	bb.addPrComment("my-repo", 1, "please rename this")
+	bb.addPrTask("my-repo", 1, "rename the function", true, 1)
+	bb.addPrTask("my-repo", 1, "update the docs", false, 0)
+
+	config := testSettings(t, bb, gh)
+	ghClient, bbClient, err := newClients(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrateRepos(ghClient, bbClient, []repoEntry{{name: "my-repo", slug: "my-repo"}}, config)
+
+	repo := gh.repo("my-repo")
+	if len(repo.issues) != 2 {
+		t.Fatalf("expected 2 PRs, got %d", len(repo.issues))
+	}
+	body := repo.issues[0].Body
+	for _, expected := range []string{
+		"- [x] rename the function (created by Test Reviewer, on comment \"please rename this\")",
+		"- [ ] update the docs (created by Test Reviewer)",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q in the PR body:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(repo.issues[1].Body, "Tasks in Bitbucket") {
+		t.Errorf("PR without tasks got a task list:\n%s", repo.issues[1].Body)
+	}
+}